@@ -0,0 +1,26 @@
+package cache
+
+import (
+	"context"
+	"github.com/go-redis/redis/v8"
+	"time"
+)
+
+func authorizationCodeKey(codeHash string) string {
+	return codeHash + ":authorizationcode"
+}
+
+func SaveAuthorizationCode(codeHash string, value []byte, ttl time.Duration) error {
+	rdb := RedisConnectionPool.Get().(*redis.Client)
+	defer RedisConnectionPool.Put(rdb)
+
+	return rdb.Set(context.TODO(), authorizationCodeKey(codeHash), value, ttl).Err()
+}
+
+// TakeAuthorizationCode returns the value saved for the code and deletes it, so every code can only be exchanged once
+func TakeAuthorizationCode(codeHash string) ([]byte, error) {
+	rdb := RedisConnectionPool.Get().(*redis.Client)
+	defer RedisConnectionPool.Put(rdb)
+
+	return rdb.GetDel(context.TODO(), authorizationCodeKey(codeHash)).Bytes()
+}
@@ -0,0 +1,31 @@
+package cache
+
+import (
+	"context"
+	"github.com/go-redis/redis/v8"
+	"time"
+)
+
+// incrementWithin counts up key, the count starts over once window has passed since the first increment
+func incrementWithin(key string, window time.Duration) (int64, error) {
+	rdb := RedisConnectionPool.Get().(*redis.Client)
+	defer RedisConnectionPool.Put(rdb)
+
+	ctx := context.TODO()
+
+	count, err := rdb.Incr(ctx, key).Result()
+
+	if err != nil {
+		return 0, err
+	}
+
+	if count == 1 {
+		err = rdb.Expire(ctx, key, window).Err()
+
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	return count, nil
+}
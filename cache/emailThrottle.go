@@ -0,0 +1,13 @@
+package cache
+
+import "time"
+
+// RecordVerificationResend counts a resent verification email for address within window
+func RecordVerificationResend(address string, window time.Duration) (int64, error) {
+	return incrementWithin(address+":verificationresends", window)
+}
+
+// RecordMagicLinkRequest counts a login link sent to address within window
+func RecordMagicLinkRequest(address string, window time.Duration) (int64, error) {
+	return incrementWithin(address+":magiclinkrequests", window)
+}
@@ -0,0 +1,36 @@
+package cache
+
+import (
+	"context"
+	"github.com/go-redis/redis/v8"
+	"time"
+)
+
+func failedLoginKey(key string) string {
+	return key + ":failedlogins"
+}
+
+// RecordFailedLogin counts a failed login for key, the count starts over once window has passed since the first failure
+func RecordFailedLogin(key string, window time.Duration) (int64, error) {
+	return incrementWithin(failedLoginKey(key), window)
+}
+
+func FailedLogins(key string) int64 {
+	rdb := RedisConnectionPool.Get().(*redis.Client)
+	defer RedisConnectionPool.Put(rdb)
+
+	count, err := rdb.Get(context.TODO(), failedLoginKey(key)).Int64()
+
+	if err != nil {
+		return 0
+	}
+
+	return count
+}
+
+func ResetFailedLogins(key string) error {
+	rdb := RedisConnectionPool.Get().(*redis.Client)
+	defer RedisConnectionPool.Put(rdb)
+
+	return rdb.Del(context.TODO(), failedLoginKey(key)).Err()
+}
@@ -0,0 +1,26 @@
+package cache
+
+import (
+	"context"
+	"github.com/go-redis/redis/v8"
+	"time"
+)
+
+func oidcStateKey(state string) string {
+	return state + ":oidcstate"
+}
+
+func SaveOidcState(state string, value []byte, ttl time.Duration) error {
+	rdb := RedisConnectionPool.Get().(*redis.Client)
+	defer RedisConnectionPool.Put(rdb)
+
+	return rdb.Set(context.TODO(), oidcStateKey(state), value, ttl).Err()
+}
+
+// TakeOidcState returns the value saved for state and deletes it, so every state can only be used once
+func TakeOidcState(state string) ([]byte, error) {
+	rdb := RedisConnectionPool.Get().(*redis.Client)
+	defer RedisConnectionPool.Put(rdb)
+
+	return rdb.GetDel(context.TODO(), oidcStateKey(state)).Bytes()
+}
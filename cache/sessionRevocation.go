@@ -0,0 +1,41 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"github.com/go-redis/cache/v8"
+	"github.com/go-redis/redis/v8"
+	"time"
+)
+
+func revokedSessionKey(sessionId string) string {
+	return sessionId + ":revokedsession"
+}
+
+// RevokeSession marks a session as revoked, ttl should be at least as long as an access token lives
+func RevokeSession(sessionId string, ttl time.Duration) error {
+	rdb := RedisCachePool.Get().(*cache.Cache)
+	defer RedisCachePool.Put(rdb)
+
+	return rdb.Set(&cache.Item{
+		Ctx:   context.TODO(),
+		Key:   revokedSessionKey(sessionId),
+		Value: true,
+		TTL:   ttl,
+	})
+}
+
+// IsSessionRevoked fails closed, a session is treated as revoked when Redis can't be asked
+func IsSessionRevoked(sessionId string) bool {
+	rdb := RedisConnectionPool.Get().(*redis.Client)
+	defer RedisConnectionPool.Put(rdb)
+
+	count, err := rdb.Exists(context.TODO(), revokedSessionKey(sessionId)).Result()
+
+	if err != nil {
+		fmt.Println("Error checking session revocation...", err)
+		return true
+	}
+
+	return count > 0
+}
@@ -3,6 +3,7 @@ package config
 import (
 	"fmt"
 	"os"
+	"strconv"
 
 	"github.com/joho/godotenv"
 )
@@ -15,3 +16,12 @@ func Config(key string) string {
 	}
 	return os.Getenv(key)
 }
+
+// ConfigInt returns fallback when the key is not set or is not a number
+func ConfigInt(key string, fallback int) int {
+	v, err := strconv.Atoi(Config(key))
+	if err != nil {
+		return fallback
+	}
+	return v
+}
@@ -3,6 +3,7 @@ package database
 import (
 	"context"
 	"example.com/app/config"
+	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 	"time"
@@ -12,6 +13,15 @@ type Connection struct {
 	*mongo.Client
 	UserCollection *mongo.Collection
 	FlagCollection *mongo.Collection
+	RefreshTokenCollection *mongo.Collection
+	SessionCollection *mongo.Collection
+	IdentityCollection *mongo.Collection
+	OAuthClientCollection *mongo.Collection
+	ConsentCollection *mongo.Collection
+	ApiKeyCollection *mongo.Collection
+	DeviceCollection *mongo.Collection
+	SecurityActivityCollection *mongo.Collection
+	ImpersonationAuditCollection *mongo.Collection
 	*mongo.Database
 }
 
@@ -32,8 +42,51 @@ func ConnectToDB() (*Connection,error) {
 	// create collection
 	userCollection := db.Collection("users")
 	flagCollection := db.Collection("flags")
+	refreshTokenCollection := db.Collection("refreshTokens")
+	sessionCollection := db.Collection("sessions")
+	identityCollection := db.Collection("identities")
+	oauthClientCollection := db.Collection("oauthClients")
+	consentCollection := db.Collection("consents")
+	apiKeyCollection := db.Collection("apiKeys")
+	deviceCollection := db.Collection("devices")
+	securityActivityCollection := db.Collection("securityActivity")
+	impersonationAuditCollection := db.Collection("impersonationAudit")
 
-	dbConnection := &Connection{client, userCollection, flagCollection, db}
+	// an account at a provider can only be linked to one user
+	_, err = identityCollection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{"provider", 1}, {"subject", 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	if err != nil { return nil, err }
+
+	// concurrent logins from a new device must not record it twice
+	_, err = deviceCollection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{"userId", 1}, {"fingerprint", 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	if err != nil { return nil, err }
+
+	// the activity of a user is read newest first a page at a time
+	_, err = securityActivityCollection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{"userId", 1}, {"_id", -1}},
+	})
+	if err != nil { return nil, err }
+
+	// user listings are sorted by followers or by username a page at a time, the id breaks ties
+	_, err = userCollection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{"followerCount", -1}, {"_id", -1}}},
+		{Keys: bson.D{{"username", 1}, {"_id", 1}}},
+	})
+	if err != nil { return nil, err }
+
+	// user search, a username match counts for more than a tagline match
+	_, err = userCollection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{"username", "text"}, {"currentTagLine", "text"}},
+		Options: options.Index().SetWeights(bson.D{{"username", 10}, {"currentTagLine", 1}}),
+	})
+	if err != nil { return nil, err }
+
+	dbConnection := &Connection{client, userCollection, flagCollection, refreshTokenCollection, sessionCollection, identityCollection, oauthClientCollection, consentCollection, apiKeyCollection, deviceCollection, securityActivityCollection, impersonationAuditCollection, db}
 
 	return dbConnection, nil
 }
\ No newline at end of file
@@ -0,0 +1,102 @@
+package domain
+
+import (
+	authHelper "example.com/app/helpers"
+	"fmt"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"strings"
+	"time"
+)
+
+// ApiKeyPrefix every key starts with it, that's how IsLoggedIn tells keys from tokens
+const ApiKeyPrefix = "uak_"
+
+// API key scopes, keys can also have any permission their user has as a scope
+const (
+	ApiKeyScopeRead  = "read"
+	ApiKeyScopeWrite = "write"
+)
+
+// ApiKey a credential for scripts and bots acting for a user, only the signed hash of the key is stored
+type ApiKey struct {
+	Id      primitive.ObjectID `bson:"_id" json:"id"`
+	UserId  primitive.ObjectID `bson:"userId" json:"-"`
+	Name    string             `bson:"name" json:"name"`
+	KeyHash string             `bson:"keyHash" json:"-"`
+	Scopes  []string           `bson:"scopes" json:"scopes"`
+	// ExpiresAt 0 when the key doesn't expire
+	ExpiresAt  int64     `bson:"expiresAt" json:"expiresAt"`
+	LastUsedAt time.Time `bson:"lastUsedAt" json:"lastUsedAt"`
+	CreatedAt  time.Time `bson:"createdAt" json:"createdAt"`
+}
+
+// CreateApiKey todo validate struct
+type CreateApiKey struct {
+	Name   string   `json:"name"`
+	Scopes []string `json:"scopes"`
+	// ExpiresInDays 0 for a key that doesn't expire
+	ExpiresInDays int `json:"expiresInDays"`
+}
+
+// ApiKeyCredentials the key is only shown once, when it's created
+type ApiKeyCredentials struct {
+	Id  primitive.ObjectID `json:"id"`
+	Key string             `json:"key"`
+}
+
+// ApiKeyFromHeader the key in an Authorization header, false when the header holds a token
+func ApiKeyFromHeader(tokenValue string) (string, bool) {
+	data, err := authHelper.ExtractData(tokenValue)
+
+	if err != nil || len(data) != 1 || !strings.HasPrefix(data[0], ApiKeyPrefix) {
+		return "", false
+	}
+
+	return data[0], true
+}
+
+// ApiKeyAuthentication the caller is the key's user with the permissions both the user and the key have,
+// expired keys and keys of locked accounts are refused
+func ApiKeyAuthentication(apiKey *ApiKey, user *User) (*Authentication, error) {
+	if apiKey.ExpiresAt != 0 && apiKey.ExpiresAt < time.Now().Unix() {
+		return nil, fmt.Errorf("api key has expired")
+	}
+
+	if user.IsLocked && user.LockedUntil > time.Now().Unix() {
+		return nil, ErrAccountLocked
+	}
+
+	l := new(Authentication)
+	l.Id = user.Id
+	l.Username = user.Username
+	l.Role = user.Role
+	l.ApiKeyId = apiKey.Id
+	l.Scopes = apiKey.Scopes
+	l.Permissions = make([]string, 0)
+
+	for _, p := range EffectivePermissions(user.Role, user.Permissions) {
+		if hasScope(apiKey.Scopes, p) {
+			l.Permissions = append(l.Permissions, p)
+		}
+	}
+
+	return l, nil
+}
+
+func IsApiKeyScope(scope string) bool {
+	return scope == ApiKeyScopeRead || scope == ApiKeyScopeWrite || IsPermission(scope)
+}
+
+// AllowsMethod api keys need the read scope for GET and HEAD requests and the write scope for everything else,
+// tokens from a login can do both
+func (l Authentication) AllowsMethod(method string) bool {
+	if l.ApiKeyId.IsZero() {
+		return true
+	}
+
+	if method == "GET" || method == "HEAD" {
+		return hasScope(l.Scopes, ApiKeyScopeRead)
+	}
+
+	return hasScope(l.Scopes, ApiKeyScopeWrite)
+}
@@ -0,0 +1,94 @@
+package domain
+
+import (
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestApiKeyAuthentication(t *testing.T) {
+	now := time.Now().Unix()
+
+	tests := []struct {
+		name        string
+		apiKey      ApiKey
+		user        User
+		err         error
+		permissions []string
+	}{
+		{
+			name:        "permissions both the user and the key have",
+			apiKey:      ApiKey{Scopes: []string{ApiKeyScopeRead, PermissionUnlockUsers, PermissionManageRoles}},
+			user:        User{Role: RoleUser, Permissions: []string{PermissionUnlockUsers}},
+			permissions: []string{PermissionUnlockUsers},
+		},
+		{
+			name:   "expired key",
+			apiKey: ApiKey{ExpiresAt: now - 60},
+			user:   User{Role: RoleUser},
+		},
+		{
+			name:        "key that doesn't expire",
+			apiKey:      ApiKey{ExpiresAt: 0},
+			user:        User{Role: RoleUser},
+			permissions: []string{},
+		},
+		{
+			name:   "locked account",
+			apiKey: ApiKey{},
+			user:   User{Role: RoleUser, IsLocked: true, LockedUntil: now + 60},
+			err:    ErrAccountLocked,
+		},
+		{
+			name:        "lock that has run out",
+			apiKey:      ApiKey{},
+			user:        User{Role: RoleUser, IsLocked: true, LockedUntil: now - 60},
+			permissions: []string{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.apiKey.Id = primitive.NewObjectID()
+			auth, err := ApiKeyAuthentication(&tt.apiKey, &tt.user)
+
+			if tt.permissions == nil {
+				if err == nil {
+					t.Fatal("the key was accepted")
+				}
+				if tt.err != nil && err != tt.err {
+					t.Fatalf("got %v, want %v", err, tt.err)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if auth.ApiKeyId != tt.apiKey.Id || !reflect.DeepEqual(auth.Permissions, tt.permissions) {
+				t.Errorf("got key %v with %v, want %v with %v", auth.ApiKeyId, auth.Permissions, tt.apiKey.Id, tt.permissions)
+			}
+		})
+	}
+}
+
+func TestApiKeyFromHeader(t *testing.T) {
+	tests := []struct {
+		header string
+		key    string
+		ok     bool
+	}{
+		{"Bearer uak_abc", "uak_abc", true},
+		{"Bearer eyJhbGciOi.payload.sig", "", false},
+		{"uak_abc", "", false},
+		{"Bearer uak_abc|sig", "", false},
+	}
+
+	for _, tt := range tests {
+		if key, ok := ApiKeyFromHeader(tt.header); key != tt.key || ok != tt.ok {
+			t.Errorf("ApiKeyFromHeader(%q) = %q, %v, want %q, %v", tt.header, key, ok, tt.key, tt.ok)
+		}
+	}
+}
@@ -3,6 +3,7 @@ package domain
 import (
 	"crypto/hmac"
 	"crypto/sha256"
+	"example.com/app/cache"
 	"example.com/app/config"
 	authHelper "example.com/app/helpers"
 	"fmt"
@@ -16,6 +17,14 @@ import (
 type Authentication struct {
 	Id primitive.ObjectID
 	Username string `bson:"username" json:"username"`
+	SessionId string `bson:"sessionId" json:"-"`
+	Role string `bson:"role" json:"role"`
+	Permissions []string `bson:"permissions" json:"permissions"`
+	// ApiKeyId and Scopes are only set when the caller used an api key instead of a token
+	ApiKeyId primitive.ObjectID `bson:"-" json:"-"`
+	Scopes []string `bson:"-" json:"-"`
+	// Actor is only set when an admin is impersonating the user
+	Actor *Actor `bson:"-" json:"-"`
 }
 
 // LoginDetails todo validate struct
@@ -30,20 +39,86 @@ type ResetPasswordQuery struct {
 	Email string `bson:"email" json:"email"`
 }
 
+// MagicLinkQuery todo validate struct
+type MagicLinkQuery struct {
+	Email string `json:"email"`
+}
+
+// ResendVerification todo validate struct
+type ResendVerification struct {
+	Email string `json:"email"`
+}
+
+// ChangePassword todo validate struct
+type ChangePassword struct {
+	CurrentPassword string `json:"currentPassword"`
+	NewPassword     string `json:"newPassword"`
+}
+
+// ChangeEmail todo validate struct
+type ChangeEmail struct {
+	Email    string `json:"email"`
+	Password string `json:"password"`
+}
+
 // ResetPassword todo validate struct
 type ResetPassword struct {
 	Password string `bson:"password" json:"password"`
 }
 
+// MfaCode todo validate struct
+type MfaCode struct {
+	Code string `json:"code"`
+}
+
+// MfaVerification todo validate struct
+type MfaVerification struct {
+	MfaToken string `json:"mfaToken"`
+	Code     string `json:"code"`
+}
+
+type MfaEnrollment struct {
+	Secret string `json:"secret"`
+	Uri    string `json:"uri"`
+}
+
 type Claims struct {
 	jwt.StandardClaims
-	Id       primitive.ObjectID
-	Username string
+	Id         primitive.ObjectID
+	Username   string
+	SessionId   string
+	Role        string
+	Permissions []string
+	MfaPending  bool
+	Actor       *Actor `json:"act,omitempty"`
 }
 
 var k = config.Config("SECRET")
 
-func (l Authentication) GenerateJWT(msg User) (string, error){
+var ErrAccountLocked = fmt.Errorf("account is locked, try again later")
+
+var ErrTooManyAttempts = fmt.Errorf("too many failed login attempts, try again later")
+
+var ErrWrongPassword = fmt.Errorf("password is incorrect")
+
+const (
+	LoginMethodPassword  = "password"
+	LoginMethodMfa       = "mfa"
+	LoginMethodMagicLink = "magic_link"
+	// LoginMethodOidc is followed by the provider, e.g. oidc:google
+	LoginMethodOidc      = "oidc"
+)
+
+var ErrTooManyRequests = fmt.Errorf("too many requests, try again later")
+
+// VerificationCodeExpiresAt when a verification code created now stops working, VERIFICATION_CODE_EXPIRATION is in minutes
+func VerificationCodeExpiresAt() int64 {
+	expiration := time.Duration(config.ConfigInt("VERIFICATION_CODE_EXPIRATION", 1440)) * time.Minute
+	return time.Now().Add(expiration).Unix()
+}
+
+// GenerateJWT issues an access token bound to sessionId, logging out revokes the session
+func (l Authentication) GenerateJWT(msg User, sessionId string) (string, error){
 	e, err := strconv.Atoi(config.Config("EXPIRATION"))
 
 	if err != nil {
@@ -54,12 +129,14 @@ func (l Authentication) GenerateJWT(msg User) (string, error){
 		StandardClaims: jwt.StandardClaims{
 			ExpiresAt: time.Now().Add(time.Duration(e) * time.Minute).Unix(),
 		},
-		Id:       msg.Id,
-		Username: msg.Username,
+		Id:          msg.Id,
+		Username:    msg.Username,
+		SessionId:   sessionId,
+		Role:        msg.Role,
+		Permissions: EffectivePermissions(msg.Role, msg.Permissions),
 	}
 	// always better to use a pointer with JSON
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, &claims)
-	signedString, err := token.SignedString([]byte(k))
+	signedString, err := GetSigningKeys().sign(&claims)
 
 	if err != nil {
 		return "", err
@@ -67,6 +144,66 @@ func (l Authentication) GenerateJWT(msg User) (string, error){
 	return signedString, nil
 }
 
+// GenerateMfaPendingJWT issues a short lived token proving the password was correct, it can only be exchanged at /auth/mfa/verify
+func (l Authentication) GenerateMfaPendingJWT(msg User) (string, error){
+	e := config.ConfigInt("MFA_PENDING_EXPIRATION", 5)
+
+	claims := Claims{
+		StandardClaims: jwt.StandardClaims{
+			ExpiresAt: time.Now().Add(time.Duration(e) * time.Minute).Unix(),
+		},
+		Id:         msg.Id,
+		Username:   msg.Username,
+		MfaPending: true,
+	}
+
+	return GetSigningKeys().sign(&claims)
+}
+
+func (l Authentication) ParseMfaPendingJWT(tokenValue string) (*Authentication, error) {
+	token, err := jwt.ParseWithClaims(tokenValue, &Claims{}, GetSigningKeys().verificationKey)
+
+	if err != nil {
+		return nil, err
+	}
+
+	claims := token.Claims.(*Claims)
+
+	if !token.Valid || !claims.MfaPending {
+		return nil, fmt.Errorf("token is not valid")
+	}
+
+	l.Id = claims.Id
+	l.Username = strings.ToLower(claims.Username)
+	return &l, nil
+}
+
+// UseLegacyTokenFormat TOKEN_FORMAT=legacy keeps issuing "token|signature" tokens for clients that haven't migrated
+func UseLegacyTokenFormat() bool {
+	return strings.ToLower(config.Config("TOKEN_FORMAT")) == "legacy"
+}
+
+// legacyTokenAccepted "token|signature" tokens are accepted until LEGACY_TOKEN_ACCEPTED_UNTIL (RFC 3339), or forever when it's not set
+func legacyTokenAccepted() bool {
+	if UseLegacyTokenFormat() {
+		return true
+	}
+
+	until := config.Config("LEGACY_TOKEN_ACCEPTED_UNTIL")
+
+	if until == "" {
+		return true
+	}
+
+	t, err := time.Parse(time.RFC3339, until)
+
+	if err != nil {
+		return false
+	}
+
+	return time.Now().Before(t)
+}
+
 func (l Authentication) SignToken(token []byte) ([]byte, error) {
 	// second arg is a private key, key needs to be the same size as hasher
 	// sha512 is 64 bits
@@ -99,23 +236,29 @@ func(l Authentication) IsLoggedIn(tokenValue string) (*Authentication, bool, err
 		return nil,false, err
 	}
 
-	validSig, err := l.VerifySignature([]byte(data[0]), []byte(data[1]))
-
-	if err != nil {
-		return nil, false, err
+	// api keys are looked up by the middleware, they never get here
+	if len(data) == 1 && strings.HasPrefix(data[0], ApiKeyPrefix) {
+		return nil, false, fmt.Errorf("api keys aren't tokens")
 	}
 
-	if !validSig {
-		return nil, false, err
-	}
+	if len(data) == 2 {
+		if !legacyTokenAccepted() {
+			return nil, false, fmt.Errorf("legacy token format is no longer accepted")
+		}
 
-	token, err := jwt.ParseWithClaims(data[0], &Claims{},func(t *jwt.Token)(interface{}, error) {
-		if t.Method.Alg() == jwt.SigningMethodHS256.Alg() {
-			//verify token(we pass in our key to be verified)
-			return []byte(k), nil
+		validSig, err := l.VerifySignature([]byte(data[0]), []byte(data[1]))
+
+		if err != nil {
+			return nil, false, err
 		}
-		return nil, err
-	})
+
+		if !validSig {
+			return nil, false, fmt.Errorf("invalid token signature")
+		}
+	}
+
+	// the key is picked by the kid header
+	token, err := jwt.ParseWithClaims(data[0], &Claims{}, GetSigningKeys().verificationKey)
 
 	if err != nil {
 		return nil, false, err
@@ -128,8 +271,20 @@ func(l Authentication) IsLoggedIn(tokenValue string) (*Authentication, bool, err
 		// because we receive an interface type we need to assert which type we want to use that inherits it
 		claims := token.Claims.(*Claims)
 
+		if claims.MfaPending {
+			return nil, false, fmt.Errorf("mfa verification required")
+		}
+
+		if claims.SessionId == "" || cache.IsSessionRevoked(claims.SessionId) {
+			return nil, false, fmt.Errorf("session has been revoked")
+		}
+
 		l.Id = claims.Id
 		l.Username = strings.ToLower(claims.Username)
+		l.SessionId = claims.SessionId
+		l.Role = claims.Role
+		l.Permissions = claims.Permissions
+		l.Actor = claims.Actor
 		return &l, true, nil
 	}
 
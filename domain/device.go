@@ -0,0 +1,39 @@
+package domain
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"time"
+)
+
+const (
+	// DeviceStatusPending the user hasn't said yet whether the login from this device was them
+	DeviceStatusPending   = "pending"
+	DeviceStatusConfirmed = "confirmed"
+	// DeviceStatusDenied logins from the device are refused until the user confirms it
+	DeviceStatusDenied = "denied"
+)
+
+var ErrDeviceDenied = fmt.Errorf("logins from this device have been denied")
+
+// Device a user agent and IP the user logged in from, one per fingerprint
+type Device struct {
+	Id          primitive.ObjectID `bson:"_id" json:"id"`
+	UserId      primitive.ObjectID `bson:"userId" json:"-"`
+	Fingerprint string             `bson:"fingerprint" json:"-"`
+	UserAgent   string             `bson:"userAgent" json:"userAgent"`
+	Ip          string             `bson:"ip" json:"ip"`
+	// Country ISO code, empty without a GeoIP database
+	Country     string    `bson:"country" json:"country"`
+	Status      string    `bson:"status" json:"status"`
+	FirstSeenAt time.Time `bson:"firstSeenAt" json:"firstSeenAt"`
+	LastSeenAt  time.Time `bson:"lastSeenAt" json:"lastSeenAt"`
+}
+
+// DeviceFingerprint the user agent and IP hashed together, a new browser or a new network is a new device
+func DeviceFingerprint(userAgent string, ip string) string {
+	sum := sha256.Sum256([]byte(userAgent + "|" + ip))
+	return hex.EncodeToString(sum[:])
+}
@@ -8,5 +8,7 @@ type Event struct {
 	ResourceId primitive.ObjectID `bson:"resourceId" json:"resourceId"`
 	ActorUsername string `bson:"actorUsername" json:"actorUsername"`
 	Message string `bson:"message" json:"message"`
+	// Method how the user authenticated, only set on login events
+	Method string `bson:"method,omitempty" json:"method,omitempty"`
 }
 
@@ -0,0 +1,47 @@
+package domain
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"example.com/app/config"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"time"
+)
+
+// Identity an account at an OpenID Connect provider linked to a user, a user has at most one per provider
+type Identity struct {
+	Id        primitive.ObjectID `bson:"_id" json:"-"`
+	UserId    primitive.ObjectID `bson:"userId" json:"-"`
+	Provider  string             `bson:"provider" json:"provider"`
+	Subject   string             `bson:"subject" json:"-"`
+	Email     string             `bson:"email" json:"email"`
+	CreatedAt time.Time          `bson:"createdAt" json:"createdAt"`
+}
+
+// OidcState what the callback needs to finish a login, it's kept in redis under the state parameter
+type OidcState struct {
+	Provider string `json:"provider"`
+	Verifier string `json:"verifier"`
+	Nonce    string `json:"nonce"`
+	// LinkUserId is set when a logged in user links the provider instead of logging in with it
+	LinkUserId primitive.ObjectID `json:"linkUserId"`
+	// BindingHash of the value in the cookie of the browser that started the flow, a callback from another
+	// browser can't finish it
+	BindingHash string `json:"bindingHash"`
+}
+
+// OidcStateExpiration how long a login with a provider can take, OIDC_STATE_EXPIRATION is in minutes
+func OidcStateExpiration() time.Duration {
+	return time.Duration(config.ConfigInt("OIDC_STATE_EXPIRATION", 10)) * time.Minute
+}
+
+func OidcBindingHash(binding string) string {
+	sum := sha256.Sum256([]byte(binding))
+	return hex.EncodeToString(sum[:])
+}
+
+// MatchesBinding true when binding is the one the flow was started with
+func (s OidcState) MatchesBinding(binding string) bool {
+	return binding != "" && subtle.ConstantTimeCompare([]byte(OidcBindingHash(binding)), []byte(s.BindingHash)) == 1
+}
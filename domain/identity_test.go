@@ -0,0 +1,27 @@
+package domain
+
+import "testing"
+
+func TestOidcStateMatchesBinding(t *testing.T) {
+	s := OidcState{BindingHash: OidcBindingHash("binding")}
+
+	tests := []struct {
+		name    string
+		state   OidcState
+		binding string
+		matches bool
+	}{
+		{"the browser that started the flow", s, "binding", true},
+		{"another browser", s, "other", false},
+		{"no cookie", s, "", false},
+		{"a state without a binding", OidcState{}, "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.state.MatchesBinding(tt.binding); got != tt.matches {
+				t.Errorf("got %v, want %v", got, tt.matches)
+			}
+		})
+	}
+}
@@ -0,0 +1,87 @@
+package domain
+
+import (
+	"example.com/app/config"
+	"fmt"
+	"github.com/dgrijalva/jwt-go"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"time"
+)
+
+// the actions of the impersonation audit log
+const (
+	ImpersonationStarted = "impersonation_started"
+	ImpersonationRequest = "impersonated_request"
+	ImpersonationEnded   = "impersonation_ended"
+)
+
+var ErrImpersonating = fmt.Errorf("this can't be done while impersonating a user")
+
+// Actor the admin behind an impersonation token, it's the act claim of RFC 8693 while the rest of the claims are the user
+type Actor struct {
+	Id       primitive.ObjectID `json:"id"`
+	Username string             `json:"username"`
+}
+
+// ImpersonationAudit one entry per impersonated request, plus the start and the end of the impersonation
+type ImpersonationAudit struct {
+	Id            primitive.ObjectID `bson:"_id" json:"id"`
+	Action        string             `bson:"action" json:"action"`
+	ActorId       primitive.ObjectID `bson:"actorId" json:"actorId"`
+	ActorUsername string             `bson:"actorUsername" json:"actorUsername"`
+	UserId        primitive.ObjectID `bson:"userId" json:"userId"`
+	Username      string             `bson:"username" json:"username"`
+	// SessionId of the impersonation token, it groups the requests of one impersonation
+	SessionId string    `bson:"sessionId" json:"sessionId"`
+	Method    string    `bson:"method,omitempty" json:"method,omitempty"`
+	Path      string    `bson:"path,omitempty" json:"path,omitempty"`
+	Status    int       `bson:"status,omitempty" json:"status,omitempty"`
+	Ip        string    `bson:"ip" json:"ip"`
+	CreatedAt time.Time `bson:"createdAt" json:"createdAt"`
+}
+
+// ImpersonationExpiration how long an impersonation token lives, IMPERSONATION_EXPIRATION is in minutes
+func ImpersonationExpiration() time.Duration {
+	return time.Duration(config.ConfigInt("IMPERSONATION_EXPIRATION", 15)) * time.Minute
+}
+
+// IsImpersonated true when an admin is acting as the user
+func (l Authentication) IsImpersonated() bool {
+	return l.Actor != nil
+}
+
+// GenerateImpersonationJWT issues a token for msg carrying the admin as the actor, it has the user's permissions
+// and no refresh token so it can't outlive IMPERSONATION_EXPIRATION
+func (l Authentication) GenerateImpersonationJWT(msg User, actor *Actor, sessionId string) (string, error) {
+	claims := Claims{
+		StandardClaims: jwt.StandardClaims{
+			ExpiresAt: time.Now().Add(ImpersonationExpiration()).Unix(),
+		},
+		Id:          msg.Id,
+		Username:    msg.Username,
+		SessionId:   sessionId,
+		Role:        msg.Role,
+		Permissions: EffectivePermissions(msg.Role, msg.Permissions),
+		Actor:       actor,
+	}
+
+	return GetSigningKeys().sign(&claims)
+}
+
+// ImpersonationEvent the event published for an audit entry, the admin is the actor
+func ImpersonationEvent(audit *ImpersonationAudit) *Event {
+	event := new(Event)
+	event.Action = audit.Action
+	event.Target = audit.Username
+	event.ResourceId = audit.UserId
+	event.ActorUsername = audit.ActorUsername
+	event.Message = audit.ActorUsername + " as " + audit.Username
+
+	if audit.Path != "" {
+		event.Message += ": " + audit.Method + " " + audit.Path + " " + fmt.Sprint(audit.Status)
+	}
+
+	event.Message += " IP: " + audit.Ip
+
+	return event
+}
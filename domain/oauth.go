@@ -0,0 +1,290 @@
+package domain
+
+import (
+	"example.com/app/config"
+	"fmt"
+	"github.com/dgrijalva/jwt-go"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	ScopeOpenId    = "openid"
+	ScopeProfile   = "profile"
+	ScopeEmail     = "email"
+	ScopeFollowers = "followers"
+)
+
+var SupportedScopes = []string{ScopeOpenId, ScopeProfile, ScopeEmail, ScopeFollowers}
+
+// OAuthClient an app that logs its users in with this service, only the bcrypt hash of the secret is stored.
+// Public clients (mobile and single page apps) have no secret and have to use PKCE.
+type OAuthClient struct {
+	Id               primitive.ObjectID `bson:"_id" json:"-"`
+	ClientId         string             `bson:"clientId" json:"clientId"`
+	ClientSecretHash string             `bson:"clientSecretHash" json:"-"`
+	Name             string             `bson:"name" json:"name"`
+	RedirectUris     []string           `bson:"redirectUris" json:"redirectUris"`
+	Scopes           []string           `bson:"scopes" json:"scopes"`
+	IsPublic         bool               `bson:"isPublic" json:"isPublic"`
+	CreatedAt        time.Time          `bson:"createdAt" json:"createdAt"`
+}
+
+// CreateOAuthClient todo validate struct
+type CreateOAuthClient struct {
+	Name         string   `json:"name"`
+	RedirectUris []string `json:"redirectUris"`
+	Scopes       []string `json:"scopes"`
+	IsPublic     bool     `json:"isPublic"`
+}
+
+// OAuthClientCredentials the secret is only shown once, when the client is registered
+type OAuthClientCredentials struct {
+	ClientId     string `json:"clientId"`
+	ClientSecret string `json:"clientSecret,omitempty"`
+}
+
+// Consent the scopes a user allowed a client, the user isn't asked again for them
+type Consent struct {
+	Id        primitive.ObjectID `bson:"_id" json:"-"`
+	UserId    primitive.ObjectID `bson:"userId" json:"-"`
+	ClientId  string             `bson:"clientId" json:"clientId"`
+	Scopes    []string           `bson:"scopes" json:"scopes"`
+	CreatedAt time.Time          `bson:"createdAt" json:"createdAt"`
+	UpdatedAt time.Time          `bson:"updatedAt" json:"updatedAt"`
+}
+
+// AuthorizationRequest the parameters of /oauth/authorize from RFC 6749 section 4.1.1, plus the nonce and PKCE.
+// Approve is only sent with the user's answer on the consent screen.
+type AuthorizationRequest struct {
+	ResponseType        string `query:"response_type" json:"response_type"`
+	ClientId            string `query:"client_id" json:"client_id"`
+	RedirectUri         string `query:"redirect_uri" json:"redirect_uri"`
+	Scope               string `query:"scope" json:"scope"`
+	State               string `query:"state" json:"state"`
+	Nonce               string `query:"nonce" json:"nonce"`
+	CodeChallenge       string `query:"code_challenge" json:"code_challenge"`
+	CodeChallengeMethod string `query:"code_challenge_method" json:"code_challenge_method"`
+	Approve             *bool  `query:"-" json:"approve"`
+}
+
+// AuthorizationResult either where to send the user next or what to ask them for consent
+type AuthorizationResult struct {
+	RedirectUri     string   `json:"redirectUri,omitempty"`
+	ConsentRequired bool     `json:"consentRequired"`
+	ClientName      string   `json:"clientName,omitempty"`
+	Scopes          []string `json:"scopes,omitempty"`
+}
+
+// AuthorizationCode what a code stands for, kept in redis until it's exchanged
+type AuthorizationCode struct {
+	ClientId      string             `json:"clientId"`
+	UserId        primitive.ObjectID `json:"userId"`
+	RedirectUri   string             `json:"redirectUri"`
+	Scopes        []string           `json:"scopes"`
+	Nonce         string             `json:"nonce"`
+	CodeChallenge string             `json:"codeChallenge"`
+}
+
+// TokenRequest the form sent to /oauth/token
+type TokenRequest struct {
+	GrantType    string `form:"grant_type"`
+	Code         string `form:"code"`
+	RedirectUri  string `form:"redirect_uri"`
+	ClientId     string `form:"client_id"`
+	ClientSecret string `form:"client_secret"`
+	CodeVerifier string `form:"code_verifier"`
+}
+
+// OAuthTokenResponse RFC 6749 section 5.1 plus the ID token
+type OAuthTokenResponse struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	ExpiresIn   int64  `json:"expires_in"`
+	IdToken     string `json:"id_token,omitempty"`
+	Scope       string `json:"scope"`
+}
+
+// OAuthError the error codes from RFC 6749 section 5.2 and 4.1.2.1
+type OAuthError struct {
+	Code        string `json:"error"`
+	Description string `json:"error_description,omitempty"`
+}
+
+func (e *OAuthError) Error() string {
+	return e.Code + ": " + e.Description
+}
+
+// OAuthClaims access tokens for other apps, they have no SessionId so IsLoggedIn never accepts them
+type OAuthClaims struct {
+	jwt.StandardClaims
+	Scope    string `json:"scope"`
+	ClientId string `json:"client_id"`
+}
+
+var ErrSigningKeyRequired = fmt.Errorf("JWT_SIGNING_KEY_FILE is required to issue tokens for other apps")
+
+func IsScope(scope string) bool {
+	for _, s := range SupportedScopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// OAuthIssuer OIDC_ISSUER, or APP_URL when it's not set
+func OAuthIssuer() string {
+	issuer := config.Config("OIDC_ISSUER")
+
+	if issuer == "" {
+		issuer = config.Config("APP_URL")
+	}
+
+	if issuer == "" {
+		issuer = "http://127.0.0.1:8080"
+	}
+
+	return strings.TrimRight(issuer, "/")
+}
+
+// OAuthLoginUrl the page of our frontend that logs the user in and answers an authorization request, OAUTH_LOGIN_URL
+// (default APP_URL/login). returnTo is the request, the frontend sends it again with the user's token.
+func OAuthLoginUrl(returnTo string) (string, error) {
+	login := config.Config("OAUTH_LOGIN_URL")
+
+	if login == "" {
+		base := config.Config("APP_URL")
+
+		if base == "" {
+			base = "http://127.0.0.1:8080"
+		}
+
+		login = strings.TrimRight(base, "/") + "/login"
+	}
+
+	u, err := url.Parse(login)
+
+	if err != nil {
+		return "", err
+	}
+
+	q := u.Query()
+	q.Set("return_to", returnTo)
+	u.RawQuery = q.Encode()
+
+	return u.String(), nil
+}
+
+// UserInfo the claims the scopes give access to, profile and followers are the fields of ViewUserProfile
+func UserInfo(user *User, scopes []string) map[string]interface{} {
+	claims := map[string]interface{}{"sub": user.Id.Hex()}
+	profile := ViewUserProfileMapper(user)
+
+	for _, scope := range scopes {
+		switch scope {
+		case ScopeProfile:
+			claims["preferred_username"] = profile.Username
+			claims["picture"] = profile.ProfilePictureUrl
+			claims["background_picture"] = profile.ProfileBackgroundPictureUrl
+			claims["badge"] = profile.CurrentBadgeUrl
+			claims["tagline"] = profile.CurrentTagLine
+		case ScopeEmail:
+			claims["email"] = user.Email
+			claims["email_verified"] = user.IsVerified
+		case ScopeFollowers:
+			// users can hide their follower count
+			if profile.FollowerCount != nil {
+				claims["follower_count"] = *profile.FollowerCount
+			}
+		}
+	}
+
+	return claims
+}
+
+// GenerateOAuthTokens the access token and ID token for an exchanged code
+func GenerateOAuthTokens(user *User, code *AuthorizationCode) (*OAuthTokenResponse, error) {
+	keys := GetSigningKeys()
+
+	// other apps can't check tokens signed with our SECRET
+	if keys.PrivateKey == nil {
+		return nil, ErrSigningKeyRequired
+	}
+
+	now := time.Now()
+	expiresIn := time.Duration(config.ConfigInt("OAUTH_TOKEN_EXPIRATION", 60)) * time.Minute
+	scope := strings.Join(code.Scopes, " ")
+
+	accessToken, err := keys.sign(&OAuthClaims{
+		StandardClaims: jwt.StandardClaims{
+			Issuer:    OAuthIssuer(),
+			Subject:   user.Id.Hex(),
+			Audience:  code.ClientId,
+			IssuedAt:  now.Unix(),
+			ExpiresAt: now.Add(expiresIn).Unix(),
+		},
+		Scope:    scope,
+		ClientId: code.ClientId,
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	tokens := &OAuthTokenResponse{
+		AccessToken: accessToken,
+		TokenType:   "Bearer",
+		ExpiresIn:   int64(expiresIn.Seconds()),
+		Scope:       scope,
+	}
+
+	if !hasScope(code.Scopes, ScopeOpenId) {
+		return tokens, nil
+	}
+
+	idClaims := jwt.MapClaims(UserInfo(user, code.Scopes))
+	idClaims["iss"] = OAuthIssuer()
+	idClaims["aud"] = code.ClientId
+	idClaims["iat"] = now.Unix()
+	idClaims["exp"] = now.Add(expiresIn).Unix()
+
+	if code.Nonce != "" {
+		idClaims["nonce"] = code.Nonce
+	}
+
+	tokens.IdToken, err = keys.sign(idClaims)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return tokens, nil
+}
+
+// ParseOAuthAccessToken only accepts access tokens we issued to other apps
+func ParseOAuthAccessToken(token string) (*OAuthClaims, error) {
+	claims := new(OAuthClaims)
+	t, err := jwt.ParseWithClaims(token, claims, GetSigningKeys().verificationKey)
+
+	if err != nil || !t.Valid {
+		return nil, fmt.Errorf("invalid token")
+	}
+
+	if claims.Issuer != OAuthIssuer() || claims.ClientId == "" || claims.Subject == "" {
+		return nil, fmt.Errorf("invalid token")
+	}
+
+	return claims, nil
+}
+
+func hasScope(scopes []string, scope string) bool {
+	for _, s := range scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
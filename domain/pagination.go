@@ -0,0 +1,146 @@
+package domain
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"example.com/app/config"
+	"fmt"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"strconv"
+)
+
+var ErrInvalidCursor = fmt.Errorf("invalid cursor")
+
+var ErrInvalidSort = fmt.Errorf("sort must be newest, followers or username")
+
+// the orders user listings can be sorted in
+const (
+	UserSortNewest    = "newest"
+	UserSortFollowers = "followers"
+	UserSortUsername  = "username"
+)
+
+// PageRequest the ?cursor=, ?sort= and ?limit= of a listing
+type PageRequest struct {
+	Cursor string `query:"cursor"`
+	Sort   string `query:"sort"`
+	Limit  string `query:"limit"`
+}
+
+// Page one page of a cursor paginated list, NextCursor is sent back as ?cursor= to get the next page
+type Page struct {
+	Items      interface{} `json:"items"`
+	NextCursor string      `json:"nextCursor,omitempty"`
+	HasMore    bool        `json:"hasMore"`
+}
+
+// Cursor where the last page stopped, clients only ever see it encoded. Sorted listings also keep the sort
+// and the value the last item was sorted by, the id breaks ties.
+type Cursor struct {
+	Id            primitive.ObjectID `json:"id"`
+	Sort          string             `json:"sort,omitempty"`
+	FollowerCount int                `json:"followerCount,omitempty"`
+	Username      string             `json:"username,omitempty"`
+	Score         float64            `json:"score,omitempty"`
+}
+
+func (c Cursor) Encode() string {
+	b, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// DecodeCursor an empty cursor is the first page
+func DecodeCursor(cursor string) (*Cursor, error) {
+	c := new(Cursor)
+
+	if cursor == "" {
+		return c, nil
+	}
+
+	b, err := base64.RawURLEncoding.DecodeString(cursor)
+
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	if err = json.Unmarshal(b, c); err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	return c, nil
+}
+
+// PageSize the ?limit= of a request, PAGE_SIZE (default 20) when it's missing and at most PAGE_SIZE_MAX (default 100)
+func PageSize(limit string) int64 {
+	size := config.ConfigInt("PAGE_SIZE", 20)
+	max := config.ConfigInt("PAGE_SIZE_MAX", 100)
+
+	if n, err := strconv.Atoi(limit); err == nil && n > 0 {
+		size = n
+	}
+
+	if size > max {
+		size = max
+	}
+
+	return int64(size)
+}
+
+// UserPageQuery the sort order of a user listing and the filter for the users after the cursor,
+// the filter is nil on the first page
+func UserPageQuery(page *PageRequest) (bson.D, bson.M, error) {
+	sort := page.Sort
+
+	if sort == "" {
+		sort = UserSortNewest
+	}
+
+	c, err := DecodeCursor(page.Cursor)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// a cursor only means something in the order it was made for
+	if page.Cursor != "" && c.Sort != sort {
+		return nil, nil, ErrInvalidCursor
+	}
+
+	switch sort {
+	case UserSortNewest:
+		if page.Cursor == "" {
+			return bson.D{{"_id", -1}}, nil, nil
+		}
+		return bson.D{{"_id", -1}}, bson.M{"_id": bson.M{"$lt": c.Id}}, nil
+	case UserSortFollowers:
+		if page.Cursor == "" {
+			return bson.D{{"followerCount", -1}, {"_id", -1}}, nil, nil
+		}
+		return bson.D{{"followerCount", -1}, {"_id", -1}}, bson.M{"$or": []interface{}{
+			bson.M{"followerCount": bson.M{"$lt": c.FollowerCount}},
+			bson.M{"followerCount": c.FollowerCount, "_id": bson.M{"$lt": c.Id}},
+		}}, nil
+	case UserSortUsername:
+		if page.Cursor == "" {
+			return bson.D{{"username", 1}, {"_id", 1}}, nil, nil
+		}
+		return bson.D{{"username", 1}, {"_id", 1}}, bson.M{"$or": []interface{}{
+			bson.M{"username": bson.M{"$gt": c.Username}},
+			bson.M{"username": c.Username, "_id": bson.M{"$gt": c.Id}},
+		}}, nil
+	}
+
+	return nil, nil, ErrInvalidSort
+}
+
+// UserCursor the cursor of the page after last
+func UserCursor(page *PageRequest, last *UserDto) string {
+	sort := page.Sort
+
+	if sort == "" {
+		sort = UserSortNewest
+	}
+
+	return Cursor{Id: last.Id, Sort: sort, FollowerCount: last.FollowerCount, Username: last.Username}.Encode()
+}
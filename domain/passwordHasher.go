@@ -0,0 +1,214 @@
+package domain
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"example.com/app/config"
+	"fmt"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+	"strings"
+)
+
+const (
+	PasswordHashArgon2id = "argon2id"
+	PasswordHashBcrypt   = "bcrypt"
+)
+
+// PasswordHasher one hashing algorithm, hashes carry their algorithm and parameters so old ones keep verifying
+// after PASSWORD_HASH_ALGORITHM or the tuning changes
+type PasswordHasher interface {
+	Hash(password string) (string, error)
+	Verify(hash string, password string) (bool, error)
+	// NeedsRehash true when the hash wasn't made by this hasher with its current parameters
+	NeedsRehash(hash string) bool
+}
+
+// Argon2idHasher writes hashes in the PHC string format: $argon2id$v=19$m=<KiB>,t=<passes>,p=<threads>$<salt>$<key>
+type Argon2idHasher struct {
+	Memory      uint32
+	Iterations  uint32
+	Parallelism uint8
+	SaltLength  uint32
+	KeyLength   uint32
+}
+
+type argon2idParams struct {
+	memory      uint32
+	iterations  uint32
+	parallelism uint8
+	salt        []byte
+	key         []byte
+}
+
+func (h Argon2idHasher) Hash(password string) (string, error) {
+	salt := make([]byte, h.SaltLength)
+
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+
+	key := argon2.IDKey([]byte(password), salt, h.Iterations, h.Memory, h.Parallelism, h.KeyLength)
+
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s", argon2.Version, h.Memory, h.Iterations, h.Parallelism,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+func (h Argon2idHasher) Verify(hash string, password string) (bool, error) {
+	p, err := decodeArgon2id(hash)
+
+	if err != nil {
+		return false, err
+	}
+
+	key := argon2.IDKey([]byte(password), p.salt, p.iterations, p.memory, p.parallelism, uint32(len(p.key)))
+
+	return subtle.ConstantTimeCompare(key, p.key) == 1, nil
+}
+
+func (h Argon2idHasher) NeedsRehash(hash string) bool {
+	p, err := decodeArgon2id(hash)
+
+	if err != nil {
+		return true
+	}
+
+	return p.memory != h.Memory || p.iterations != h.Iterations || p.parallelism != h.Parallelism ||
+		uint32(len(p.salt)) != h.SaltLength || uint32(len(p.key)) != h.KeyLength
+}
+
+func decodeArgon2id(hash string) (*argon2idParams, error) {
+	// "", "argon2id", "v=19", "m=...,t=...,p=...", salt, key
+	parts := strings.Split(hash, "$")
+
+	if len(parts) != 6 || parts[1] != PasswordHashArgon2id {
+		return nil, fmt.Errorf("not an argon2id hash")
+	}
+
+	var version int
+
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil {
+		return nil, err
+	}
+
+	if version != argon2.Version {
+		return nil, fmt.Errorf("unsupported argon2 version %v", version)
+	}
+
+	p := &argon2idParams{}
+
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &p.memory, &p.iterations, &p.parallelism); err != nil {
+		return nil, err
+	}
+
+	// argon2 panics without a pass or a thread
+	if p.iterations < 1 || p.parallelism < 1 {
+		return nil, fmt.Errorf("invalid argon2id parameters")
+	}
+
+	var err error
+
+	if p.salt, err = base64.RawStdEncoding.DecodeString(parts[4]); err != nil {
+		return nil, err
+	}
+
+	if p.key, err = base64.RawStdEncoding.DecodeString(parts[5]); err != nil {
+		return nil, err
+	}
+
+	// an empty key would match every password
+	if len(p.salt) == 0 || len(p.key) == 0 {
+		return nil, fmt.Errorf("invalid argon2id salt or key")
+	}
+
+	return p, nil
+}
+
+type BcryptHasher struct {
+	Cost int
+}
+
+func (h BcryptHasher) Hash(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), h.Cost)
+
+	if err != nil {
+		return "", err
+	}
+
+	return string(hash), nil
+}
+
+func (h BcryptHasher) Verify(hash string, password string) (bool, error) {
+	err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
+
+	if err == bcrypt.ErrMismatchedHashAndPassword {
+		return false, nil
+	}
+
+	return err == nil, err
+}
+
+func (h BcryptHasher) NeedsRehash(hash string) bool {
+	cost, err := bcrypt.Cost([]byte(hash))
+	return err != nil || cost != h.Cost
+}
+
+// CurrentPasswordHasher the hasher new passwords use, PASSWORD_HASH_ALGORITHM is argon2id (the default) or bcrypt.
+// The argon2id defaults are the second recommended option of RFC 9106 with 64 MiB of memory.
+func CurrentPasswordHasher() PasswordHasher {
+	if config.Config("PASSWORD_HASH_ALGORITHM") == PasswordHashBcrypt {
+		return BcryptHasher{Cost: config.ConfigInt("BCRYPT_COST", bcrypt.DefaultCost)}
+	}
+
+	return Argon2idHasher{
+		Memory:      uint32(config.ConfigInt("ARGON2_MEMORY", 64*1024)),
+		Iterations:  uint32(config.ConfigInt("ARGON2_ITERATIONS", 3)),
+		Parallelism: uint8(config.ConfigInt("ARGON2_PARALLELISM", 4)),
+		SaltLength:  16,
+		KeyLength:   32,
+	}
+}
+
+// hasherFor picks the hasher from the prefix of the stored hash, the parameters are read from the hash itself
+func hasherFor(hash string) (PasswordHasher, error) {
+	switch {
+	case strings.HasPrefix(hash, "$argon2id$"):
+		return Argon2idHasher{}, nil
+	case strings.HasPrefix(hash, "$2a$"), strings.HasPrefix(hash, "$2b$"), strings.HasPrefix(hash, "$2y$"):
+		return BcryptHasher{}, nil
+	}
+	return nil, fmt.Errorf("unknown password hash format")
+}
+
+// HashPassword every new password is hashed here
+func HashPassword(password string) (string, error) {
+	return CurrentPasswordHasher().Hash(password)
+}
+
+// CheckPassword ErrWrongPassword when the password doesn't match the hash, whichever algorithm made it
+func CheckPassword(hash string, password string) error {
+	hasher, err := hasherFor(hash)
+
+	if err != nil {
+		return err
+	}
+
+	ok, err := hasher.Verify(hash, password)
+
+	if err != nil {
+		return err
+	}
+
+	if !ok {
+		return ErrWrongPassword
+	}
+
+	return nil
+}
+
+// PasswordNeedsRehash true for hashes made by an older algorithm or with older parameters,
+// they are replaced the next time the user logs in with the password
+func PasswordNeedsRehash(hash string) bool {
+	return CurrentPasswordHasher().NeedsRehash(hash)
+}
@@ -0,0 +1,142 @@
+package domain
+
+import (
+	"golang.org/x/crypto/bcrypt"
+	"os"
+	"strings"
+	"testing"
+)
+
+// withArgon2Params makes the argon2id hashes of a test cheap
+func withArgon2Params(t *testing.T, memory string, iterations string, parallelism string) {
+	for key, value := range map[string]string{"ARGON2_MEMORY": memory, "ARGON2_ITERATIONS": iterations, "ARGON2_PARALLELISM": parallelism} {
+		os.Setenv(key, value)
+		key := key
+		t.Cleanup(func() { os.Unsetenv(key) })
+	}
+}
+
+func TestArgon2idHashRoundTrips(t *testing.T) {
+	withArgon2Params(t, "64", "1", "1")
+
+	hash, err := HashPassword("Correct-Horse-9")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.HasPrefix(hash, "$argon2id$v=19$m=64,t=1,p=1$") {
+		t.Errorf("got hash %v", hash)
+	}
+
+	if err = CheckPassword(hash, "Correct-Horse-9"); err != nil {
+		t.Errorf("the password didn't match its hash: %v", err)
+	}
+
+	if err = CheckPassword(hash, "Wrong-Horse-9"); err != ErrWrongPassword {
+		t.Errorf("got %v for a wrong password, want ErrWrongPassword", err)
+	}
+
+	if PasswordNeedsRehash(hash) {
+		t.Error("a hash with the current parameters needs a rehash")
+	}
+}
+
+func TestBcryptHashesStillVerifyAndNeedARehash(t *testing.T) {
+	withArgon2Params(t, "64", "1", "1")
+
+	hash, err := bcrypt.GenerateFromPassword([]byte("Correct-Horse-9"), bcrypt.MinCost)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = CheckPassword(string(hash), "Correct-Horse-9"); err != nil {
+		t.Errorf("the password didn't match its bcrypt hash: %v", err)
+	}
+
+	if err = CheckPassword(string(hash), "Wrong-Horse-9"); err != ErrWrongPassword {
+		t.Errorf("got %v for a wrong password, want ErrWrongPassword", err)
+	}
+
+	if !PasswordNeedsRehash(string(hash)) {
+		t.Error("a bcrypt hash doesn't need a rehash under the argon2id default")
+	}
+}
+
+func TestChangedArgon2ParamsNeedARehash(t *testing.T) {
+	withArgon2Params(t, "64", "1", "1")
+
+	hash, err := HashPassword("Correct-Horse-9")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name        string
+		memory      string
+		iterations  string
+		parallelism string
+	}{
+		{"memory", "128", "1", "1"},
+		{"iterations", "64", "2", "1"},
+		{"parallelism", "64", "1", "2"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			withArgon2Params(t, tt.memory, tt.iterations, tt.parallelism)
+
+			if !PasswordNeedsRehash(hash) {
+				t.Error("the hash doesn't need a rehash")
+			}
+
+			// the old parameters are read from the hash, so it keeps verifying until it's replaced
+			if err := CheckPassword(hash, "Correct-Horse-9"); err != nil {
+				t.Errorf("the old hash stopped verifying: %v", err)
+			}
+		})
+	}
+}
+
+func TestMalformedHashesReturnAnError(t *testing.T) {
+	withArgon2Params(t, "64", "1", "1")
+
+	hash, err := HashPassword("Correct-Horse-9")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		hash string
+	}{
+		{"empty", ""},
+		{"unknown algorithm", "$argon2i$v=19$m=64,t=1,p=1$c2FsdHNhbHQ$a2V5a2V5"},
+		{"truncated", hash[:len(hash)/2]},
+		{"missing the key", strings.Join(strings.Split(hash, "$")[:5], "$")},
+		{"an empty key", strings.Join(strings.Split(hash, "$")[:5], "$") + "$"},
+		{"an empty salt", "$argon2id$v=19$m=64,t=1,p=1$$a2V5a2V5"},
+		{"another version", strings.Replace(hash, "v=19", "v=16", 1)},
+		{"no version", strings.Replace(hash, "v=19", "v=", 1)},
+		{"no parameters", strings.Replace(hash, "m=64,t=1,p=1", "", 1)},
+		{"no passes", strings.Replace(hash, "t=1", "t=0", 1)},
+		{"no threads", strings.Replace(hash, "p=1", "p=0", 1)},
+		{"a salt that isn't base64", strings.Replace(hash, "$m=64,t=1,p=1$", "$m=64,t=1,p=1$!!!", 1)},
+		{"a truncated bcrypt hash", "$2a$10$abc"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := CheckPassword(tt.hash, "Correct-Horse-9"); err == nil || err == ErrWrongPassword {
+				t.Errorf("got %v, want a malformed hash error", err)
+			}
+
+			if !PasswordNeedsRehash(tt.hash) {
+				t.Error("a malformed hash doesn't need a rehash")
+			}
+		})
+	}
+}
@@ -0,0 +1,153 @@
+package domain
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"example.com/app/config"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode"
+)
+
+// the rules a password can fail, clients can show their own message for each one
+const (
+	PasswordRuleMinLength        = "min_length"
+	PasswordRuleMaxLength        = "max_length"
+	PasswordRuleCharacterClasses = "character_classes"
+	PasswordRuleContainsUsername = "contains_username"
+	PasswordRuleContainsEmail    = "contains_email"
+	PasswordRuleReused           = "reused"
+	PasswordRuleBreached         = "breached"
+)
+
+type PasswordViolation struct {
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+// PasswordPolicyError every rule the password failed, not just the first one
+type PasswordPolicyError struct {
+	Violations []PasswordViolation `json:"violations"`
+}
+
+func (e *PasswordPolicyError) Error() string {
+	messages := make([]string, 0, len(e.Violations))
+
+	for _, v := range e.Violations {
+		messages = append(messages, v.Message)
+	}
+
+	return strings.Join(messages, ", ")
+}
+
+// ValidatePassword checks a new password against the policy, previousHashes are the user's current and old password
+// hashes. Returns a *PasswordPolicyError when a rule failed.
+func ValidatePassword(password string, username string, email string, previousHashes []string) error {
+	var violations []PasswordViolation
+
+	minLength := config.ConfigInt("PASSWORD_MIN_LENGTH", 10)
+	maxLength := config.ConfigInt("PASSWORD_MAX_LENGTH", 128)
+	length := len([]rune(password))
+
+	if length < minLength {
+		violations = append(violations, PasswordViolation{PasswordRuleMinLength, fmt.Sprintf("password must be at least %d characters", minLength)})
+	}
+
+	if length > maxLength {
+		violations = append(violations, PasswordViolation{PasswordRuleMaxLength, fmt.Sprintf("password must be at most %d characters", maxLength)})
+	}
+
+	minClasses := config.ConfigInt("PASSWORD_MIN_CHARACTER_CLASSES", 2)
+
+	if characterClasses(password) < minClasses {
+		violations = append(violations, PasswordViolation{PasswordRuleCharacterClasses, fmt.Sprintf("password must use at least %d of lowercase letters, uppercase letters, digits and symbols", minClasses)})
+	}
+
+	lower := strings.ToLower(password)
+
+	// very short names would match by accident
+	if len(username) >= 3 && strings.Contains(lower, strings.ToLower(username)) {
+		violations = append(violations, PasswordViolation{PasswordRuleContainsUsername, "password must not contain your username"})
+	}
+
+	localPart := strings.Split(strings.ToLower(email), "@")[0]
+
+	if len(localPart) >= 3 && strings.Contains(lower, localPart) {
+		violations = append(violations, PasswordViolation{PasswordRuleContainsEmail, "password must not contain your email"})
+	}
+
+	for _, hash := range previousHashes {
+		if hash != "" && CheckPassword(hash, password) == nil {
+			violations = append(violations, PasswordViolation{PasswordRuleReused, "password was used before, choose a new one"})
+			break
+		}
+	}
+
+	if isBreachedPassword(password) {
+		violations = append(violations, PasswordViolation{PasswordRuleBreached, "password appeared in a data breach, choose another one"})
+	}
+
+	if len(violations) > 0 {
+		return &PasswordPolicyError{Violations: violations}
+	}
+
+	return nil
+}
+
+func characterClasses(password string) int {
+	var lower, upper, digit, symbol int
+
+	for _, r := range password {
+		switch {
+		case unicode.IsLower(r):
+			lower = 1
+		case unicode.IsUpper(r):
+			upper = 1
+		case unicode.IsDigit(r):
+			digit = 1
+		default:
+			symbol = 1
+		}
+	}
+
+	return lower + upper + digit + symbol
+}
+
+// isBreachedPassword looks the password up in PASSWORD_BREACH_DIR, a directory of files named after the first five
+// hex characters of the SHA-1 hash with SUFFIX:COUNT lines. That's the k-anonymity layout of the Have I Been Pwned
+// range files, so only one small file is read per check and the list never has to fit in memory.
+func isBreachedPassword(password string) bool {
+	dir := config.Config("PASSWORD_BREACH_DIR")
+
+	if dir == "" {
+		return false
+	}
+
+	hash := strings.ToUpper(fmt.Sprintf("%x", sha1.Sum([]byte(password))))
+	prefix, suffix := hash[:5], hash[5:]
+
+	f, err := os.Open(filepath.Join(dir, prefix))
+
+	if err != nil {
+		if !os.IsNotExist(err) {
+			fmt.Println("Error reading breached passwords...", err)
+		}
+		return false
+	}
+
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+
+	for scanner.Scan() {
+		line := strings.ToUpper(strings.TrimSpace(scanner.Text()))
+
+		if strings.SplitN(line, ":", 2)[0] == suffix {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,148 @@
+package domain
+
+import (
+	"crypto/sha1"
+	"fmt"
+	"golang.org/x/crypto/bcrypt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// violatedRules the rules err says the password failed
+func violatedRules(t *testing.T, err error) []string {
+	if err == nil {
+		return nil
+	}
+
+	policyErr, ok := err.(*PasswordPolicyError)
+
+	if !ok {
+		t.Fatalf("got %T %v, want a *PasswordPolicyError", err, err)
+	}
+
+	rules := make([]string, 0, len(policyErr.Violations))
+
+	for _, v := range policyErr.Violations {
+		rules = append(rules, v.Rule)
+	}
+
+	return rules
+}
+
+func TestValidatePassword(t *testing.T) {
+	tests := []struct {
+		name     string
+		password string
+		rules    []string
+	}{
+		{"valid", "Correct-Horse-9", nil},
+		{"too short", "Short-9", []string{PasswordRuleMinLength}},
+		{"too long", strings.Repeat("Ab1-", 33), []string{PasswordRuleMaxLength}},
+		{"only lowercase letters", "correcthorsebattery", []string{PasswordRuleCharacterClasses}},
+		{"only uppercase letters", "CORRECTHORSEBATTERY", []string{PasswordRuleCharacterClasses}},
+		{"only digits", "12345678901234", []string{PasswordRuleCharacterClasses}},
+		{"only symbols", "!@#$%^&*()-_=+", []string{PasswordRuleCharacterClasses}},
+		{"lowercase letters and digits", "correcthorse9", nil},
+		{"uppercase letters and symbols", "CORRECT-HORSE", nil},
+		{"contains the username", "My-JDoe-Password", []string{PasswordRuleContainsUsername}},
+		{"contains the email", "John.Smith-Password", []string{PasswordRuleContainsEmail}},
+		{"fails several rules", "jdoe", []string{PasswordRuleMinLength, PasswordRuleCharacterClasses, PasswordRuleContainsUsername}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rules := violatedRules(t, ValidatePassword(tt.password, "jdoe", "john.smith@example.com", nil))
+
+			if fmt.Sprint(rules) != fmt.Sprint(tt.rules) {
+				t.Errorf("got rules %v, want %v", rules, tt.rules)
+			}
+		})
+	}
+}
+
+func TestValidatePasswordSkipsShortNames(t *testing.T) {
+	// a two letter username or email would match a lot of passwords by accident
+	if rules := violatedRules(t, ValidatePassword("Correct-Horse-jd", "jd", "jd@example.com", nil)); rules != nil {
+		t.Errorf("got rules %v", rules)
+	}
+}
+
+func TestValidatePasswordRefusesReusedPasswords(t *testing.T) {
+	withArgon2Params(t, "64", "1", "1")
+
+	bcryptHash, err := bcrypt.GenerateFromPassword([]byte("Old-Password-1"), bcrypt.MinCost)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	argon2idHash, err := HashPassword("Old-Password-2")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	history := []string{string(bcryptHash), "", argon2idHash}
+
+	tests := []struct {
+		name     string
+		password string
+		rules    []string
+	}{
+		{"a bcrypt hash in the history", "Old-Password-1", []string{PasswordRuleReused}},
+		{"an argon2id hash in the history", "Old-Password-2", []string{PasswordRuleReused}},
+		{"a new password", "New-Password-3", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rules := violatedRules(t, ValidatePassword(tt.password, "jdoe", "jdoe@example.com", history))
+
+			if fmt.Sprint(rules) != fmt.Sprint(tt.rules) {
+				t.Errorf("got rules %v, want %v", rules, tt.rules)
+			}
+		})
+	}
+}
+
+func TestValidatePasswordRefusesBreachedPasswords(t *testing.T) {
+	dir, err := ioutil.TempDir("", "breaches")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	os.Setenv("PASSWORD_BREACH_DIR", dir)
+	defer os.Unsetenv("PASSWORD_BREACH_DIR")
+
+	// a range file like the Have I Been Pwned ones, lowercase suffixes are matched too
+	hash := strings.ToUpper(fmt.Sprintf("%x", sha1.Sum([]byte("Breached-Password-1"))))
+	lines := "0018A45C4D1DEF81644B54AB7F969B88D65:3\r\n" + strings.ToLower(hash[5:]) + ":42\r\n"
+
+	if err = ioutil.WriteFile(filepath.Join(dir, hash[:5]), []byte(lines), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		password string
+		rules    []string
+	}{
+		{"in the range file", "Breached-Password-1", []string{PasswordRuleBreached}},
+		{"not in the range file", "Unbreached-Password-1", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rules := violatedRules(t, ValidatePassword(tt.password, "jdoe", "jdoe@example.com", nil))
+
+			if fmt.Sprint(rules) != fmt.Sprint(tt.rules) {
+				t.Errorf("got rules %v, want %v", rules, tt.rules)
+			}
+		})
+	}
+}
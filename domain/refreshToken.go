@@ -0,0 +1,32 @@
+package domain
+
+import (
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"time"
+)
+
+// RefreshToken only the signed hash of the token is stored, each token can be used once
+type RefreshToken struct {
+	Id        primitive.ObjectID `bson:"_id" json:"-"`
+	UserId    primitive.ObjectID `bson:"userId" json:"-"`
+	SessionId string             `bson:"sessionId" json:"-"`
+	TokenHash string             `bson:"tokenHash" json:"-"`
+	IsUsed    bool               `bson:"isUsed" json:"-"`
+	IsRevoked bool               `bson:"isRevoked" json:"-"`
+	ExpiresAt int64              `bson:"expiresAt" json:"-"`
+	CreatedAt time.Time          `bson:"createdAt" json:"-"`
+}
+
+// RefreshTokenRequest todo validate struct
+type RefreshTokenRequest struct {
+	RefreshToken string `json:"refreshToken"`
+}
+
+// AuthTokens MfaToken is set instead of the other tokens when the user still has to pass mfa
+type AuthTokens struct {
+	AccessToken  string
+	RefreshToken string
+	MfaToken     string
+	// ExpiresIn seconds until AccessToken expires
+	ExpiresIn    int64
+}
@@ -0,0 +1,71 @@
+package domain
+
+const (
+	RoleUser      = "user"
+	RoleModerator = "moderator"
+	RoleAdmin     = "admin"
+)
+
+const (
+	PermissionReadFlags     = "flags:read"
+	PermissionModerateFlags = "flags:moderate"
+	PermissionUnlockUsers   = "users:unlock"
+	PermissionManageRoles   = "users:roles"
+	PermissionManageClients = "clients:manage"
+	PermissionImpersonate   = "users:impersonate"
+)
+
+var rolePermissions = map[string][]string{
+	RoleUser:      {},
+	RoleModerator: {PermissionReadFlags, PermissionModerateFlags},
+	RoleAdmin:     {PermissionReadFlags, PermissionModerateFlags, PermissionUnlockUsers, PermissionManageRoles, PermissionManageClients, PermissionImpersonate},
+}
+
+// UpdateRole todo validate struct
+type UpdateRole struct {
+	Role        string   `json:"role"`
+	Permissions []string `json:"permissions"`
+}
+
+func IsRole(role string) bool {
+	_, ok := rolePermissions[role]
+	return ok
+}
+
+func IsPermission(permission string) bool {
+	for _, p := range rolePermissions[RoleAdmin] {
+		if p == permission {
+			return true
+		}
+	}
+	return false
+}
+
+// EffectivePermissions the permissions of the role plus the ones granted to the user directly,
+// users created before roles existed have no role and get the user role
+func EffectivePermissions(role string, granted []string) []string {
+	if role == "" {
+		role = RoleUser
+	}
+
+	permissions := make([]string, 0, len(rolePermissions[role])+len(granted))
+	seen := map[string]bool{}
+
+	for _, p := range append(rolePermissions[role], granted...) {
+		if !seen[p] {
+			seen[p] = true
+			permissions = append(permissions, p)
+		}
+	}
+
+	return permissions
+}
+
+func (l Authentication) HasPermission(permission string) bool {
+	for _, p := range l.Permissions {
+		if p == permission {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,155 @@
+package domain
+
+import (
+	"example.com/app/config"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// UserSortRelevance the order of search results, the only one they have
+const UserSortRelevance = "relevance"
+
+var ErrInvalidSearch = fmt.Errorf("q must be between 1 and 50 characters")
+
+// UserSearchHit a user that matched a search and how well, hits are cached per query before the caller's
+// blocks are filtered out
+type UserSearchHit struct {
+	User  UserDto
+	Score float64
+}
+
+// SearchQuery q trimmed and lowercased like usernames are
+func SearchQuery(q string) (string, error) {
+	q = strings.ToLower(strings.TrimSpace(q))
+
+	if q == "" || utf8.RuneCountInString(q) > 50 {
+		return "", ErrInvalidSearch
+	}
+
+	return q, nil
+}
+
+// SearchCacheKey where the hits of q are cached, q is used verbatim because its spaces change the results,
+// "john doe" is a text search and "johndoe" a username one
+func SearchCacheKey(q string) string {
+	return "searchusers:" + q
+}
+
+// SearchMaxResults how many hits a query ranks, the pages of a search never go past it
+func SearchMaxResults() int {
+	return config.ConfigInt("SEARCH_MAX_RESULTS", 200)
+}
+
+// SearchCacheTTL how long the hits of a query are reused, SEARCH_CACHE_TTL is in seconds
+func SearchCacheTTL() time.Duration {
+	return time.Duration(config.ConfigInt("SEARCH_CACHE_TTL", 60)) * time.Second
+}
+
+// SearchFuzzyDistance how many typos a username may have and still match q, queries under 3 characters
+// aren't fuzzy matched
+func SearchFuzzyDistance(q string) int {
+	n := utf8.RuneCountInString(q)
+
+	switch {
+	case n < 3:
+		return 0
+	case n < 6:
+		return 1
+	}
+	return 2
+}
+
+// SearchScore ranks how a user matched q, an exact username first, then username prefixes (shorter first),
+// then usernames within the fuzzy distance (fewer typos first), then tagline and username text matches.
+// textScore is the Mongo text score, 0 when the text search didn't match the user.
+func SearchScore(q string, username string, textScore float64) float64 {
+	if username == q {
+		return 4
+	}
+
+	if strings.HasPrefix(username, q) {
+		return 3 + float64(len(q))/float64(len(username))
+	}
+
+	if maxDistance := SearchFuzzyDistance(q); maxDistance > 0 {
+		if d := editDistance(q, username); d <= maxDistance {
+			return 2 + 1/float64(d+1)
+		}
+	}
+
+	if textScore > 0 {
+		return 1 + textScore/(textScore+1)
+	}
+
+	return 0
+}
+
+// SearchFuzzyPattern an anchored regex for the usernames that can be within the fuzzy distance of q, they start
+// with the first character of q and their length is at most the distance away from it. Anchoring keeps the
+// candidates few enough to score all of them, usernames with a typo in the first character aren't fuzzy matched.
+func SearchFuzzyPattern(q string) string {
+	runes := []rune(q)
+	maxDistance := SearchFuzzyDistance(q)
+	shortest := len(runes) - 1 - maxDistance
+
+	if shortest < 0 {
+		shortest = 0
+	}
+
+	return fmt.Sprintf("^%v.{%d,%d}$", regexp.QuoteMeta(string(runes[0])), shortest, len(runes)-1+maxDistance)
+}
+
+// SearchCursor the cursor of the page after last
+func SearchCursor(last *UserSearchHit) string {
+	return Cursor{Id: last.User.Id, Sort: UserSortRelevance, Score: last.Score, Username: last.User.Username}.Encode()
+}
+
+// After true when the hit comes after the cursor, hits are ordered by score then username
+func (h UserSearchHit) After(c *Cursor) bool {
+	return h.Score < c.Score || (h.Score == c.Score && h.User.Username > c.Username)
+}
+
+// editDistance the number of single character edits between a and b, swapping two neighbours is one edit
+// (the optimal string alignment distance)
+func editDistance(a string, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	// d[i][j] the distance between the first i runes of a and the first j runes of b
+	d := make([][]int, len(ra)+1)
+
+	for i := range d {
+		d[i] = make([]int, len(rb)+1)
+		d[i][0] = i
+	}
+
+	for j := range d[0] {
+		d[0][j] = j
+	}
+
+	for i := 1; i <= len(ra); i++ {
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+
+			d[i][j] = d[i-1][j-1] + cost
+
+			if d[i-1][j]+1 < d[i][j] {
+				d[i][j] = d[i-1][j] + 1
+			}
+
+			if d[i][j-1]+1 < d[i][j] {
+				d[i][j] = d[i][j-1] + 1
+			}
+
+			if i > 1 && j > 1 && ra[i-1] == rb[j-2] && ra[i-2] == rb[j-1] && d[i-2][j-2]+1 < d[i][j] {
+				d[i][j] = d[i-2][j-2] + 1
+			}
+		}
+	}
+
+	return d[len(ra)][len(rb)]
+}
@@ -0,0 +1,74 @@
+package domain
+
+import (
+	"regexp"
+	"testing"
+)
+
+func TestSearchFuzzyPatternFindsTheCandidatesWithinTheDistance(t *testing.T) {
+	tests := []struct {
+		q        string
+		username string
+		matches  bool
+	}{
+		{"jon", "jon", true},
+		{"jon", "jo", true},
+		{"jon", "jonn", true},
+		{"jon", "jonny", false},
+		{"johnny", "jhonny", true},
+		{"johnny", "johnnyboy", false},
+		{"johnny", "john", true},
+		{"johnny", "xohnny", false},
+		{".ab", "xab", false},
+		{"ölaf", "ölef", true},
+	}
+
+	for _, tt := range tests {
+		pattern := regexp.MustCompile(SearchFuzzyPattern(tt.q))
+
+		if got := pattern.MatchString(tt.username); got != tt.matches {
+			t.Errorf("%q matches %q: got %v, want %v", SearchFuzzyPattern(tt.q), tt.username, got, tt.matches)
+		}
+	}
+}
+
+func TestSearchScoreRanksTheMatches(t *testing.T) {
+	// from best to worst
+	ranked := []struct {
+		username  string
+		textScore float64
+	}{
+		{"johnny", 0},
+		{"johnnyb", 0},
+		{"johnnyboy", 0},
+		{"johnyy", 0},
+		{"jhonyy", 0},
+		{"bigjohnny", 0.75},
+	}
+
+	for i := 1; i < len(ranked); i++ {
+		better := SearchScore("johnny", ranked[i-1].username, ranked[i-1].textScore)
+		worse := SearchScore("johnny", ranked[i].username, ranked[i].textScore)
+
+		if better <= worse {
+			t.Errorf("%v (%v) should rank above %v (%v)", ranked[i-1].username, better, ranked[i].username, worse)
+		}
+	}
+
+	if score := SearchScore("johnny", "someone", 0); score != 0 {
+		t.Errorf("an unrelated username scored %v", score)
+	}
+}
+
+func TestSearchCacheKeyKeepsTheSpaces(t *testing.T) {
+	keys := map[string]string{}
+
+	for _, q := range []string{"johndoe", "john doe", "jo hndoe", "john  doe"} {
+		key := SearchCacheKey(q)
+
+		if other, ok := keys[key]; ok {
+			t.Errorf("%q and %q share the cache key %q", q, other, key)
+		}
+		keys[key] = q
+	}
+}
@@ -0,0 +1,32 @@
+package domain
+
+import (
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"time"
+)
+
+// the security activity a user can review, kept next to the Kafka events which are only for other services
+const (
+	ActivityLogin                = "login"
+	ActivityLoginFailed          = "login_failed"
+	ActivityAccountLocked        = "account_locked"
+	ActivityNewDeviceLogin       = "new_device_login"
+	ActivityPasswordChanged      = "password_changed"
+	ActivityPasswordReset        = "password_reset"
+	ActivityEmailChangeRequested = "email_change_requested"
+	ActivityEmailChanged         = "email_changed"
+	ActivityAccountVerified      = "account_verified"
+	ActivityImpersonated         = "impersonated"
+)
+
+type SecurityActivity struct {
+	Id     primitive.ObjectID `bson:"_id" json:"id"`
+	UserId primitive.ObjectID `bson:"userId" json:"-"`
+	Action string             `bson:"action" json:"action"`
+	// Method how the user authenticated, only set on logins
+	Method    string    `bson:"method,omitempty" json:"method,omitempty"`
+	Ip        string    `bson:"ip,omitempty" json:"ip,omitempty"`
+	UserAgent string    `bson:"userAgent,omitempty" json:"userAgent,omitempty"`
+	Country   string    `bson:"country,omitempty" json:"country,omitempty"`
+	CreatedAt time.Time `bson:"createdAt" json:"createdAt"`
+}
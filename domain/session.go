@@ -0,0 +1,21 @@
+package domain
+
+import (
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"time"
+)
+
+// Session one entry per login, the refresh tokens of a login share its SessionId
+type Session struct {
+	Id         primitive.ObjectID `bson:"_id" json:"id"`
+	UserId     primitive.ObjectID `bson:"userId" json:"-"`
+	SessionId  string             `bson:"sessionId" json:"-"`
+	DeviceId   primitive.ObjectID `bson:"deviceId" json:"deviceId"`
+	UserAgent  string             `bson:"userAgent" json:"userAgent"`
+	Ip         string             `bson:"ip" json:"ip"`
+	IsRevoked  bool               `bson:"isRevoked" json:"-"`
+	ExpiresAt  int64              `bson:"expiresAt" json:"-"`
+	CreatedAt  time.Time          `bson:"createdAt" json:"createdAt"`
+	LastSeenAt time.Time          `bson:"lastSeenAt" json:"lastSeenAt"`
+	IsCurrent  bool               `bson:"-" json:"isCurrent"`
+}
@@ -0,0 +1,177 @@
+package domain
+
+import (
+	"crypto"
+	"example.com/app/config"
+	authHelper "example.com/app/helpers"
+	"fmt"
+	"github.com/dgrijalva/jwt-go"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SigningKeys tokens are signed with the private key and checked with whichever verification key the kid header names.
+// Rotating keys means signing with a new key while the old public key stays in JWT_VERIFICATION_KEYS
+// until the last token it signed has expired.
+type SigningKeys struct {
+	Kid              string
+	Method           jwt.SigningMethod
+	PrivateKey       crypto.Signer
+	VerificationKeys map[string]crypto.PublicKey
+}
+
+type Jwks struct {
+	Keys []authHelper.JWK `json:"keys"`
+}
+
+var signingKeys *SigningKeys
+var loadSigningKeys sync.Once
+
+// GetSigningKeys loads the keys once, without JWT_SIGNING_KEY_FILE tokens are signed with the shared SECRET (HS256)
+func GetSigningKeys() *SigningKeys {
+	loadSigningKeys.Do(func() {
+		keys, err := loadKeys()
+		if err != nil {
+			panic(fmt.Sprintf("loading signing keys failed: %v", err))
+		}
+		signingKeys = keys
+	})
+	return signingKeys
+}
+
+func loadKeys() (*SigningKeys, error) {
+	keys := &SigningKeys{VerificationKeys: map[string]crypto.PublicKey{}}
+
+	// format is kid=path,kid=path
+	for _, entry := range strings.Split(config.Config("JWT_VERIFICATION_KEYS"), ",") {
+		if strings.TrimSpace(entry) == "" {
+			continue
+		}
+
+		kv := strings.SplitN(entry, "=", 2)
+
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("invalid verification key %v", entry)
+		}
+
+		publicKey, err := authHelper.LoadPublicKey(strings.TrimSpace(kv[1]))
+
+		if err != nil {
+			return nil, err
+		}
+
+		keys.VerificationKeys[strings.TrimSpace(kv[0])] = publicKey
+	}
+
+	path := config.Config("JWT_SIGNING_KEY_FILE")
+
+	if path == "" {
+		return keys, nil
+	}
+
+	kid := config.Config("JWT_SIGNING_KEY_ID")
+
+	if kid == "" {
+		return nil, fmt.Errorf("JWT_SIGNING_KEY_ID is required with JWT_SIGNING_KEY_FILE")
+	}
+
+	privateKey, err := authHelper.LoadPrivateKey(path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	method, err := authHelper.SigningMethodForKey(privateKey.Public())
+
+	if err != nil {
+		return nil, err
+	}
+
+	keys.Kid = kid
+	keys.Method = method
+	keys.PrivateKey = privateKey
+	keys.VerificationKeys[kid] = privateKey.Public()
+
+	return keys, nil
+}
+
+func (s *SigningKeys) Jwks() (*Jwks, error) {
+	jwks := &Jwks{Keys: make([]authHelper.JWK, 0, len(s.VerificationKeys))}
+
+	for kid, key := range s.VerificationKeys {
+		jwk, err := authHelper.PublicKeyToJWK(kid, key)
+
+		if err != nil {
+			return nil, err
+		}
+
+		jwks.Keys = append(jwks.Keys, *jwk)
+	}
+
+	sort.Slice(jwks.Keys, func(i, j int) bool {
+		return jwks.Keys[i].Kid < jwks.Keys[j].Kid
+	})
+
+	return jwks, nil
+}
+
+func (s *SigningKeys) sign(claims jwt.Claims) (string, error) {
+	if s.PrivateKey == nil {
+		token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+		return token.SignedString([]byte(k))
+	}
+
+	token := jwt.NewWithClaims(s.Method, claims)
+	token.Header["kid"] = s.Kid
+
+	return token.SignedString(s.PrivateKey)
+}
+
+// verificationKey is the jwt.Keyfunc for our tokens, tokens without a kid were signed with SECRET
+func (s *SigningKeys) verificationKey(t *jwt.Token) (interface{}, error) {
+	kid, ok := t.Header["kid"].(string)
+
+	if !ok {
+		if t.Method.Alg() == jwt.SigningMethodHS256.Alg() && s.secretTokenAccepted(time.Now()) {
+			return []byte(k), nil
+		}
+		return nil, fmt.Errorf("unexpected signing method")
+	}
+
+	key, found := s.VerificationKeys[kid]
+
+	if !found {
+		return nil, fmt.Errorf("unknown key id")
+	}
+
+	method, err := authHelper.SigningMethodForKey(key)
+
+	if err != nil {
+		return nil, err
+	}
+
+	// the alg has to match the key, otherwise a public key could be used as an HMAC secret
+	if method.Alg() != t.Method.Alg() {
+		return nil, fmt.Errorf("unexpected signing method")
+	}
+
+	return key, nil
+}
+
+// secretTokenAccepted SECRET signs the tokens until a private key is configured, after that the tokens it signed
+// are only accepted until HS256_TOKEN_ACCEPTED_UNTIL (RFC 3339), so the shared secret can't mint tokens forever
+func (s *SigningKeys) secretTokenAccepted(now time.Time) bool {
+	if s.PrivateKey == nil {
+		return true
+	}
+
+	until, err := time.Parse(time.RFC3339, config.Config("HS256_TOKEN_ACCEPTED_UNTIL"))
+
+	if err != nil {
+		return false
+	}
+
+	return now.Before(until)
+}
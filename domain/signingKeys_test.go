@@ -0,0 +1,50 @@
+package domain
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"github.com/dgrijalva/jwt-go"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestSecretTokensAreRefusedOnceAPrivateKeyIsConfigured(t *testing.T) {
+	_, privateKey, err := ed25519.GenerateKey(rand.Reader)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	secretToken, err := jwt.NewWithClaims(jwt.SigningMethodHS256, &Claims{Username: "jdoe"}).SignedString([]byte(k))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		keys     *SigningKeys
+		until    string
+		accepted bool
+	}{
+		{"no private key", &SigningKeys{}, "", true},
+		{"private key", &SigningKeys{PrivateKey: privateKey}, "", false},
+		{"private key within the migration window", &SigningKeys{PrivateKey: privateKey}, time.Now().Add(time.Hour).Format(time.RFC3339), true},
+		{"private key after the migration window", &SigningKeys{PrivateKey: privateKey}, time.Now().Add(-time.Hour).Format(time.RFC3339), false},
+		{"private key with an invalid migration window", &SigningKeys{PrivateKey: privateKey}, "next week", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			os.Setenv("HS256_TOKEN_ACCEPTED_UNTIL", tt.until)
+			defer os.Unsetenv("HS256_TOKEN_ACCEPTED_UNTIL")
+
+			_, err := jwt.ParseWithClaims(secretToken, &Claims{}, tt.keys.verificationKey)
+
+			if accepted := err == nil; accepted != tt.accepted {
+				t.Errorf("got accepted %v, want %v (%v)", accepted, tt.accepted, err)
+			}
+		})
+	}
+}
@@ -1,16 +1,21 @@
 package domain
 
 import (
+	"fmt"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"time"
 )
 
+var ErrUsernameTaken = fmt.Errorf("username is taken")
+
 // User todo validate struct
 type User struct {
 	Id                          primitive.ObjectID   `bson:"_id" json:"id"`
 	Username                    string               `bson:"username" json:"username"`
 	Email                       string               `bson:"email" json:"email"`
+	PendingEmail                string               `bson:"pendingEmail" json:"-"`
 	Password                    string               `bson:"password" json:"-"`
+	PasswordHistory             []string             `bson:"passwordHistory" json:"-"`
 	CurrentTagLine              string               `bson:"currentTagLine" json:"CurrentTagLine"`
 	UnlockedTagLine             []string             `bson:"unlockedTagLine" json:"unlockedTagLine"`
 	ProfilePictureUrl           string               `bson:"profilePictureUrl" json:"profilePictureUrl"`
@@ -25,14 +30,26 @@ type User struct {
 	FollowerCount               int                  `bson:"followerCount" json:"followerCount"`
 	DisplayFollowerCount        bool                 `bson:"displayFollowerCount" json:"displayFollowerCount"`
 	ProfileIsViewable           bool                 `bson:"profileIsViewable" json:"profileIsViewable"`
+	Role                        string               `bson:"role" json:"-"`
+	Permissions                 []string             `bson:"permissions" json:"-"`
 	IsLocked                    bool                 `bson:"isLocked" json:"-"`
+	LockedUntil                 int64                `bson:"lockedUntil" json:"-"`
+	LockCount                   int                  `bson:"lockCount" json:"-"`
 	IsVerified                  bool                 `bson:"isVerified" json:"isVerified"`
 	AcceptMessages              bool                 `bson:"acceptMessages" json:"acceptMessages"`
 	TokenHash                   string               `bson:"tokenHash" json:"-"`
 	VerificationCode            string               `bson:"verificationCode" json:"-"`
+	VerificationCodeExpiresAt   int64                `bson:"verificationCodeExpiresAt" json:"-"`
+	VerificationRemindedAt      int64                `bson:"verificationRemindedAt" json:"-"`
 	TokenExpiresAt              int64                `bson:"tokenExpiresAt" json:"-"`
+	MagicLinkHash               string               `bson:"magicLinkHash" json:"-"`
+	MagicLinkExpiresAt          int64                `bson:"magicLinkExpiresAt" json:"-"`
 	LastLoginIp					string				 `bson:"lastLoginIp" json:"-"`
 	LastLoginIps				[]string			 `bson:"lastLoginIps" json:"-"`
+	MfaEnabled                  bool                 `bson:"mfaEnabled" json:"-"`
+	MfaSecret                   string               `bson:"mfaSecret" json:"-"`
+	MfaLastUsedStep             int64                `bson:"mfaLastUsedStep" json:"-"`
+	MfaRecoveryCodes            []string             `bson:"mfaRecoveryCodes" json:"-"`
 	CreatedAt                   time.Time            `bson:"createdAt" json:"-"`
 	UpdatedAt                   time.Time            `bson:"updatedAt" json:"-"`
 }
@@ -85,18 +102,18 @@ type UpdateDisplayFollowerCount struct {
 
 type UserDto struct {
 	Id                          primitive.ObjectID   `bson:"_id" json:"-"`
-	Email                       string               `json:"email"`
-	Username                    string               `json:"username"`
-	CurrentTagLine              string               `json:"currentTagLine"`
-	UnlockedTagLine             []string             `json:"unlockedTagLine"`
-	ProfilePictureUrl           string               `json:"profilePictureUrl"`
-	ProfileBackgroundPictureUrl string               `json:"profileBackgroundPictureUrl"`
-	CurrentBadgeUrl             string               `json:"currentBadgeUrl"`
-	UnlockedBadgesUrls          []string             `json:"unlockedBadgesUrls"`
-	ProfileIsViewable           bool                 `json:"profileIsViewable"`
-	AcceptMessages              bool                 `json:"acceptMessages"`
-	FollowerCount               int                  `json:"followerCount"`
-	DisplayFollowerCount        bool                 `json:"displayFollowerCount"`
+	Email                       string               `bson:"email" json:"email"`
+	Username                    string               `bson:"username" json:"username"`
+	CurrentTagLine              string               `bson:"currentTagLine" json:"currentTagLine"`
+	UnlockedTagLine             []string             `bson:"unlockedTagLine" json:"unlockedTagLine"`
+	ProfilePictureUrl           string               `bson:"profilePictureUrl" json:"profilePictureUrl"`
+	ProfileBackgroundPictureUrl string               `bson:"profileBackgroundPictureUrl" json:"profileBackgroundPictureUrl"`
+	CurrentBadgeUrl             string               `bson:"currentBadgeUrl" json:"currentBadgeUrl"`
+	UnlockedBadgesUrls          []string             `bson:"unlockedBadgesUrls" json:"unlockedBadgesUrls"`
+	ProfileIsViewable           bool                 `bson:"profileIsViewable" json:"profileIsViewable"`
+	AcceptMessages              bool                 `bson:"acceptMessages" json:"acceptMessages"`
+	FollowerCount               int                  `bson:"followerCount" json:"followerCount"`
+	DisplayFollowerCount        bool                 `bson:"displayFollowerCount" json:"displayFollowerCount"`
 	Followers                   []string             `bson:"followers" json:"-"`
 	Following                   []string             `bson:"following" json:"-"`
 	IsVerified                  bool                 `bson:"isVerified" json:"-"`
@@ -113,11 +130,22 @@ type ViewUserProfile struct {
 	ProfilePictureUrl           string               `json:"profilePictureUrl"`
 	ProfileBackgroundPictureUrl string               `json:"profileBackgroundPictureUrl"`
 	CurrentBadgeUrl             string               `json:"currentBadgeUrl"`
-	FollowerCount               int                  `json:"followerCount"`
+	// FollowerCount nil when the user hides it
+	FollowerCount               *int                 `json:"followerCount,omitempty"`
 	DisplayFollowerCount        bool                 `json:"displayFollowerCount"`
 }
 
+// the lists of users a user has
+const (
+	FollowListFollowers = "followers"
+	FollowListFollowing = "following"
+	// FollowListMutuals the users who follow the user back
+	FollowListMutuals = "mutuals"
+)
+
+// UserResponse one page of users, handlers turn it into a Page of the view the caller may see
 type UserResponse struct {
-	Users       *[]UserDto
-	CurrentPage string
+	Users      *[]UserDto
+	NextCursor string
+	HasMore    bool
 }
@@ -6,12 +6,14 @@ func UserMapper(user *User) *UserDto {
 	userDto.Email = user.Email
 	userDto.Username = user.Username
 	userDto.ProfilePictureUrl = user.ProfilePictureUrl
+	userDto.ProfileBackgroundPictureUrl = user.ProfileBackgroundPictureUrl
 	userDto.CurrentTagLine = user.CurrentTagLine
 	userDto.UnlockedTagLine = user.UnlockedTagLine
 	userDto.CurrentBadgeUrl = user.CurrentBadgeUrl
 	userDto.ProfileIsViewable = user.ProfileIsViewable
 	userDto.UnlockedBadgesUrls = user.UnlockedBadgesUrls
 	userDto.AcceptMessages = user.AcceptMessages
+	userDto.IsVerified = user.IsVerified
 	userDto.DisplayFollowerCount = user.DisplayFollowerCount
 	userDto.FollowerCount = user.FollowerCount
 	userDto.Following = user.Following
@@ -26,6 +28,7 @@ func UserDtoMapper(dto UserDto) *User {
 	user.Email = dto.Email
 	user.Username = dto.Username
 	user.ProfilePictureUrl = dto.ProfilePictureUrl
+	user.ProfileBackgroundPictureUrl = dto.ProfileBackgroundPictureUrl
 	user.CurrentTagLine = dto.CurrentTagLine
 	user.UnlockedTagLine = dto.UnlockedTagLine
 	user.CurrentBadgeUrl = dto.CurrentBadgeUrl
@@ -38,6 +41,24 @@ func UserDtoMapper(dto UserDto) *User {
 	user.Followers = dto.Followers
 	user.FollowerCount = dto.FollowerCount
 	user.Following = dto.Following
+	user.BlockList = dto.BlockList
+	user.BlockByList = dto.BlockByList
 
 	return user
-}
\ No newline at end of file
+}
+func ViewUserProfileMapper(user *User) *ViewUserProfile {
+	profile := new(ViewUserProfile)
+	profile.Username = user.Username
+	profile.CurrentTagLine = user.CurrentTagLine
+	profile.ProfilePictureUrl = user.ProfilePictureUrl
+	profile.ProfileBackgroundPictureUrl = user.ProfileBackgroundPictureUrl
+	profile.CurrentBadgeUrl = user.CurrentBadgeUrl
+	profile.DisplayFollowerCount = user.DisplayFollowerCount
+
+	if user.DisplayFollowerCount {
+		followerCount := user.FollowerCount
+		profile.FollowerCount = &followerCount
+	}
+
+	return profile
+}
@@ -4,7 +4,7 @@ package domain
 // messageType 200 user updated
 // messageType 204 user deleted
 type Message struct {
-	User User `form:"User" json:"User"`
+	User UserEventView `form:"User" json:"User"`
 	Event        Event  `form:"Event" json:"Event"`
 	MessageType int `form:"messageType" json:"messageType"`
 	ResourceType string `form:"resourceType" json:"resourceType"`
@@ -0,0 +1,171 @@
+package domain
+
+import (
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"time"
+)
+
+// Every user that leaves the service goes through one of these views, each one lists the fields it may show.
+// ViewUserProfile is the public view, what other users see.
+
+// UserSelfView what users see of their own account
+type UserSelfView struct {
+	Username                    string   `json:"username"`
+	Email                       string   `json:"email"`
+	CurrentTagLine              string   `json:"currentTagLine"`
+	UnlockedTagLine             []string `json:"unlockedTagLine"`
+	ProfilePictureUrl           string   `json:"profilePictureUrl"`
+	ProfileBackgroundPictureUrl string   `json:"profileBackgroundPictureUrl"`
+	CurrentBadgeUrl             string   `json:"currentBadgeUrl"`
+	UnlockedBadgesUrls          []string `json:"unlockedBadgesUrls"`
+	ProfileIsViewable           bool     `json:"profileIsViewable"`
+	AcceptMessages              bool     `json:"acceptMessages"`
+	FollowerCount               int      `json:"followerCount"`
+	DisplayFollowerCount        bool     `json:"displayFollowerCount"`
+	IsVerified                  bool     `json:"isVerified"`
+}
+
+// UserAdminView the self view plus what admins need to manage the account, never secrets or login details
+type UserAdminView struct {
+	UserSelfView
+	Id          primitive.ObjectID `json:"id"`
+	Role        string             `json:"role"`
+	Permissions []string           `json:"permissions"`
+	FlagCount   int                `json:"flagCount"`
+	IsLocked    bool               `json:"isLocked"`
+	LockedUntil int64              `json:"lockedUntil"`
+	MfaEnabled  bool               `json:"mfaEnabled"`
+	CreatedAt   time.Time          `json:"createdAt"`
+	UpdatedAt   time.Time          `json:"updatedAt"`
+}
+
+// FollowView a user in a followers, following or mutuals list and how they relate to the caller
+type FollowView struct {
+	ViewUserProfile
+	IsFollowedByMe bool `json:"isFollowedByMe"`
+	FollowsMe      bool `json:"followsMe"`
+}
+
+// UserEventView the user in the Kafka messages other services consume, the field names are the ones of User.
+// The email stays out of it like out of the public view, services that need it ask for it.
+type UserEventView struct {
+	Id                          primitive.ObjectID
+	Username                    string
+	CurrentTagLine              string
+	UnlockedTagLine             []string
+	ProfilePictureUrl           string
+	ProfileBackgroundPictureUrl string
+	CurrentBadgeUrl             string
+	UnlockedBadgesUrls          []string
+	BlockList                   []string
+	BlockByList                 []string
+	Followers                   []string
+	Following                   []string
+	FollowerCount               int
+	DisplayFollowerCount        bool
+	ProfileIsViewable           bool
+	AcceptMessages              bool
+	IsVerified                  bool
+	Role                        string
+	CreatedAt                   time.Time
+	UpdatedAt                   time.Time
+}
+
+func UserSelfViewMapper(user *User) *UserSelfView {
+	view := new(UserSelfView)
+	view.Username = user.Username
+	view.Email = user.Email
+	view.CurrentTagLine = user.CurrentTagLine
+	view.UnlockedTagLine = user.UnlockedTagLine
+	view.ProfilePictureUrl = user.ProfilePictureUrl
+	view.ProfileBackgroundPictureUrl = user.ProfileBackgroundPictureUrl
+	view.CurrentBadgeUrl = user.CurrentBadgeUrl
+	view.UnlockedBadgesUrls = user.UnlockedBadgesUrls
+	view.ProfileIsViewable = user.ProfileIsViewable
+	view.AcceptMessages = user.AcceptMessages
+	view.FollowerCount = user.FollowerCount
+	view.DisplayFollowerCount = user.DisplayFollowerCount
+	view.IsVerified = user.IsVerified
+
+	return view
+}
+
+func UserAdminViewMapper(user *User) *UserAdminView {
+	view := new(UserAdminView)
+	view.UserSelfView = *UserSelfViewMapper(user)
+	view.Id = user.Id
+	view.Role = user.Role
+	view.Permissions = EffectivePermissions(user.Role, user.Permissions)
+	view.FlagCount = len(user.FlagCount)
+	view.IsLocked = user.IsLocked
+	view.LockedUntil = user.LockedUntil
+	view.MfaEnabled = user.MfaEnabled
+	view.CreatedAt = user.CreatedAt
+	view.UpdatedAt = user.UpdatedAt
+
+	return view
+}
+
+func UserEventViewMapper(user *User) *UserEventView {
+	view := new(UserEventView)
+	view.Id = user.Id
+	view.Username = user.Username
+	view.CurrentTagLine = user.CurrentTagLine
+	view.UnlockedTagLine = user.UnlockedTagLine
+	view.ProfilePictureUrl = user.ProfilePictureUrl
+	view.ProfileBackgroundPictureUrl = user.ProfileBackgroundPictureUrl
+	view.CurrentBadgeUrl = user.CurrentBadgeUrl
+	view.UnlockedBadgesUrls = user.UnlockedBadgesUrls
+	view.BlockList = user.BlockList
+	view.BlockByList = user.BlockByList
+	view.Followers = user.Followers
+	view.Following = user.Following
+	view.FollowerCount = user.FollowerCount
+	view.DisplayFollowerCount = user.DisplayFollowerCount
+	view.ProfileIsViewable = user.ProfileIsViewable
+	view.AcceptMessages = user.AcceptMessages
+	view.IsVerified = user.IsVerified
+	view.Role = user.Role
+	view.CreatedAt = user.CreatedAt
+	view.UpdatedAt = user.UpdatedAt
+
+	return view
+}
+
+// ViewUserProfileListMapper the public view of every user in a list
+func ViewUserProfileListMapper(users []UserDto) []ViewUserProfile {
+	profiles := make([]ViewUserProfile, 0, len(users))
+
+	for i := range users {
+		profiles = append(profiles, *ViewUserProfileMapper(UserDtoMapper(users[i])))
+	}
+
+	return profiles
+}
+
+// FollowViewListMapper the public view of every user in a list, flagged with whether viewer follows them
+// and whether they follow viewer
+func FollowViewListMapper(users []UserDto, viewer *UserDto) []FollowView {
+	following := make(map[string]bool, len(viewer.Following))
+	followers := make(map[string]bool, len(viewer.Followers))
+
+	for _, username := range viewer.Following {
+		following[username] = true
+	}
+
+	for _, username := range viewer.Followers {
+		followers[username] = true
+	}
+
+	views := make([]FollowView, 0, len(users))
+
+	for i := range users {
+		views = append(views, FollowView{
+			ViewUserProfile: *ViewUserProfileMapper(UserDtoMapper(users[i])),
+			IsFollowedByMe:  following[users[i].Username],
+			FollowsMe:       followers[users[i].Username],
+		})
+	}
+
+	return views
+}
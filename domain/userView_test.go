@@ -0,0 +1,200 @@
+package domain
+
+import (
+	"encoding/json"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"strings"
+	"testing"
+	"time"
+)
+
+// the values of the fields no view may show, they're unique so they can be looked for in the JSON
+var secrets = map[string]string{
+	"password":         "secret-password-hash",
+	"tokenHash":        "secret-token-hash",
+	"verificationCode": "secret-verification-code",
+	"magicLinkHash":    "secret-magic-link-hash",
+	"lastLoginIp":      "203.0.113.7",
+	"lastLoginIps":     "203.0.113.8",
+	"mfaSecret":        "SECRETMFASECRET",
+	"mfaRecoveryCodes": "secret-recovery-code",
+	"passwordHistory":  "secret-old-password-hash",
+	"pendingEmail":     "pending@example.com",
+}
+
+const email = "jdoe@example.com"
+
+func testUser(displayFollowerCount bool) *User {
+	return &User{
+		Id:                   primitive.NewObjectID(),
+		Username:             "jdoe",
+		Email:                email,
+		PendingEmail:         secrets["pendingEmail"],
+		Password:             secrets["password"],
+		PasswordHistory:      []string{secrets["passwordHistory"]},
+		CurrentTagLine:       "hello",
+		Followers:            []string{"alice"},
+		Following:            []string{"bob"},
+		FollowerCount:        1,
+		DisplayFollowerCount: displayFollowerCount,
+		ProfileIsViewable:    true,
+		Role:                 RoleUser,
+		TokenHash:            secrets["tokenHash"],
+		VerificationCode:     secrets["verificationCode"],
+		MagicLinkHash:        secrets["magicLinkHash"],
+		LastLoginIp:          secrets["lastLoginIp"],
+		LastLoginIps:         []string{secrets["lastLoginIps"]},
+		MfaEnabled:           true,
+		MfaSecret:            secrets["mfaSecret"],
+		MfaRecoveryCodes:     []string{secrets["mfaRecoveryCodes"]},
+		CreatedAt:            time.Now(),
+		UpdatedAt:            time.Now(),
+	}
+}
+
+// testUserDto the user as the list queries decode it
+func testUserDto(displayFollowerCount bool) UserDto {
+	u := testUser(displayFollowerCount)
+	dto := *UserMapper(u)
+	dto.TokenHash = u.TokenHash
+	dto.VerificationCode = u.VerificationCode
+
+	return dto
+}
+
+func marshalView(t *testing.T, view interface{}) (string, map[string]interface{}) {
+	b, err := json.Marshal(view)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var fields map[string]interface{}
+
+	// list views are checked through their first item
+	if strings.HasPrefix(string(b), "[") {
+		var items []map[string]interface{}
+
+		if err = json.Unmarshal(b, &items); err != nil || len(items) == 0 {
+			t.Fatalf("got %s (%v)", b, err)
+		}
+
+		fields = items[0]
+	} else if err = json.Unmarshal(b, &fields); err != nil {
+		t.Fatal(err)
+	}
+
+	return string(b), fields
+}
+
+func TestUserViews(t *testing.T) {
+	viewer := testUserDto(true)
+	viewer.Username = "alice"
+	viewer.Following = []string{"jdoe"}
+
+	tests := []struct {
+		name string
+		view func(displayFollowerCount bool) interface{}
+		// showsEmail the self and admin views are the only ones with the email
+		showsEmail bool
+		// hidesFollowerCount followerCount is left out when the user doesn't display it
+		hidesFollowerCount bool
+		fields             []string
+	}{
+		{
+			name:       "self",
+			view:       func(d bool) interface{} { return UserSelfViewMapper(testUser(d)) },
+			showsEmail: true,
+			fields:     []string{"username", "email", "followerCount", "displayFollowerCount", "isVerified"},
+		},
+		{
+			name:       "admin",
+			view:       func(d bool) interface{} { return UserAdminViewMapper(testUser(d)) },
+			showsEmail: true,
+			fields:     []string{"username", "email", "id", "role", "permissions", "flagCount", "isLocked", "mfaEnabled"},
+		},
+		{
+			name:   "event",
+			view:   func(d bool) interface{} { return UserEventViewMapper(testUser(d)) },
+			fields: []string{"Id", "Username", "Followers", "Following", "FollowerCount", "Role"},
+		},
+		{
+			name:               "public profile",
+			view:               func(d bool) interface{} { return ViewUserProfileMapper(testUser(d)) },
+			hidesFollowerCount: true,
+			fields:             []string{"username", "currentTagLine", "displayFollowerCount"},
+		},
+		{
+			name:               "public list",
+			view:               func(d bool) interface{} { return ViewUserProfileListMapper([]UserDto{testUserDto(d)}) },
+			hidesFollowerCount: true,
+			fields:             []string{"username", "currentTagLine", "displayFollowerCount"},
+		},
+		{
+			name: "follow list",
+			view: func(d bool) interface{} {
+				return FollowViewListMapper([]UserDto{testUserDto(d)}, &viewer)
+			},
+			hidesFollowerCount: true,
+			fields:             []string{"username", "currentTagLine", "displayFollowerCount", "isFollowedByMe", "followsMe"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, displayFollowerCount := range []bool{true, false} {
+				raw, fields := marshalView(t, tt.view(displayFollowerCount))
+
+				for field, secret := range secrets {
+					if strings.Contains(raw, secret) {
+						t.Errorf("the %v shows up in %v", field, raw)
+					}
+				}
+
+				if showsEmail := strings.Contains(raw, email); showsEmail != tt.showsEmail {
+					t.Errorf("got email shown %v, want %v: %v", showsEmail, tt.showsEmail, raw)
+				}
+
+				for _, field := range tt.fields {
+					if _, ok := fields[field]; !ok {
+						t.Errorf("%v is missing from %v", field, raw)
+					}
+				}
+
+				_, hasFollowerCount := fields["followerCount"]
+
+				if tt.hidesFollowerCount && hasFollowerCount != displayFollowerCount {
+					t.Errorf("got followerCount %v with displayFollowerCount %v: %v", hasFollowerCount, displayFollowerCount, raw)
+				}
+			}
+		})
+	}
+}
+
+func TestFollowViewListMapperFlagsTheRelationToTheViewer(t *testing.T) {
+	viewer := UserDto{Username: "alice", Following: []string{"bob", "carol"}, Followers: []string{"carol", "dave"}}
+	users := []UserDto{{Username: "bob"}, {Username: "carol"}, {Username: "dave"}, {Username: "erin"}}
+
+	want := map[string][2]bool{
+		"bob":   {true, false},
+		"carol": {true, true},
+		"dave":  {false, true},
+		"erin":  {false, false},
+	}
+
+	views := FollowViewListMapper(users, &viewer)
+
+	if len(views) != len(users) {
+		t.Fatalf("got %v views for %v users", len(views), len(users))
+	}
+
+	for i, view := range views {
+		if view.Username != users[i].Username {
+			t.Errorf("view %v is %v, want %v", i, view.Username, users[i].Username)
+		}
+
+		if got := [2]bool{view.IsFollowedByMe, view.FollowsMe}; got != want[view.Username] {
+			t.Errorf("%v: got isFollowedByMe, followsMe %v, want %v", view.Username, got, want[view.Username])
+		}
+	}
+}
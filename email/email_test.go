@@ -0,0 +1,144 @@
+package email
+
+import (
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTemplates(t *testing.T) {
+	os.Setenv("APP_URL", "https://users.example.com/")
+	defer os.Unsetenv("APP_URL")
+
+	tests := []struct {
+		name    string
+		build   func() (*Message, error)
+		subject string
+		link    string
+	}{
+		{"verification", func() (*Message, error) { return VerificationEmail("jdoe@example.com", "jdoe", "code123") },
+			"Verify your email", "https://users.example.com/auth/account/code123"},
+		{"verification reminder", func() (*Message, error) {
+			return VerificationReminderEmail("jdoe@example.com", "jdoe", "code123", time.Date(2030, 1, 2, 3, 4, 5, 0, time.UTC))
+		}, "Verify your email to keep your account", "https://users.example.com/auth/account/code123"},
+		{"magic link", func() (*Message, error) { return MagicLinkEmail("jdoe@example.com", "jdoe", "token123") },
+			"Your login link", "https://users.example.com/auth/magic-link/token123"},
+		{"password reset", func() (*Message, error) { return PasswordResetEmail("jdoe@example.com", "jdoe", "token123") },
+			"Reset your password", "https://users.example.com/auth/reset/token123"},
+		{"security alert", func() (*Message, error) {
+			return SecurityAlertEmail("jdoe@example.com", "jdoe", "New login", "Someone logged in")
+		}, "New login", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg, err := tt.build()
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if msg.To != "jdoe@example.com" || msg.Subject != tt.subject {
+				t.Errorf("got to %q subject %q", msg.To, msg.Subject)
+			}
+
+			for part, body := range map[string]string{"text": msg.Text, "html": msg.Html} {
+				if !strings.Contains(body, "Hi jdoe,") {
+					t.Errorf("the %v part doesn't greet the user:\n%v", part, body)
+				}
+
+				if !strings.Contains(body, tt.link) {
+					t.Errorf("the %v part doesn't have the link %v:\n%v", part, tt.link, body)
+				}
+			}
+		})
+	}
+}
+
+func TestHtmlTemplatesEscapeUserInput(t *testing.T) {
+	msg, err := PasswordResetEmail("jdoe@example.com", "<script>alert(1)</script>", "token123")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if strings.Contains(msg.Html, "<script>") {
+		t.Errorf("the username wasn't escaped:\n%v", msg.Html)
+	}
+}
+
+func TestFileMailerDeliversIntoTheMaildir(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mail")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	msg, err := PasswordResetEmail("jdoe@example.com", "jdoe", "token123")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = FileMailer{dir: dir, from: "no-reply@example.com"}.Send(msg)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	delivered, _ := filepath.Glob(filepath.Join(dir, "new", "*.eml"))
+	pending, _ := filepath.Glob(filepath.Join(dir, "tmp", "*"))
+
+	if len(delivered) != 1 || len(pending) != 0 {
+		t.Fatalf("got %v delivered and %v pending emails, want 1 and 0", len(delivered), len(pending))
+	}
+
+	f, err := os.Open(delivered[0])
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	email, err := mail.ReadMessage(f)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if email.Header.Get("From") != "no-reply@example.com" || email.Header.Get("To") != "jdoe@example.com" ||
+		email.Header.Get("Subject") != "Reset your password" {
+		t.Errorf("got headers %v", email.Header)
+	}
+
+	mediaType, params, err := mime.ParseMediaType(email.Header.Get("Content-Type"))
+
+	if err != nil || mediaType != "multipart/alternative" {
+		t.Fatalf("got content type %v (%v)", mediaType, err)
+	}
+
+	r := multipart.NewReader(email.Body, params["boundary"])
+	parts := map[string]string{}
+
+	for {
+		p, err := r.NextPart()
+
+		if err != nil {
+			break
+		}
+
+		b, _ := ioutil.ReadAll(p)
+		parts[strings.Split(p.Header.Get("Content-Type"), ";")[0]] = string(b)
+	}
+
+	if parts["text/plain"] != msg.Text || parts["text/html"] != msg.Html {
+		t.Errorf("the parts don't match the message: %v", parts)
+	}
+}
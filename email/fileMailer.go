@@ -0,0 +1,58 @@
+package email
+
+import (
+	"crypto/rand"
+	"example.com/app/config"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// FileMailer writes every message into a maildir (MAIL_DIR) instead of sending it, for local development and tests
+type FileMailer struct {
+	dir  string
+	from string
+}
+
+func (m FileMailer) Send(msg *Message) error {
+	b, err := buildMIME(m.from, msg)
+
+	if err != nil {
+		return err
+	}
+
+	for _, sub := range []string{"tmp", "new", "cur"} {
+		err = os.MkdirAll(filepath.Join(m.dir, sub), 0755)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	r := make([]byte, 4)
+	_, _ = rand.Read(r)
+	name := fmt.Sprintf("%d.%x.user-service.eml", time.Now().UnixNano(), r)
+
+	// maildir delivery, the file only shows up in new once it's complete
+	tmp := filepath.Join(m.dir, "tmp", name)
+
+	err = ioutil.WriteFile(tmp, b, 0644)
+
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, filepath.Join(m.dir, "new", name))
+}
+
+func NewFileMailer() FileMailer {
+	dir := config.Config("MAIL_DIR")
+
+	if dir == "" {
+		dir = "mail"
+	}
+
+	return FileMailer{dir: dir, from: sender()}
+}
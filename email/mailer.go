@@ -0,0 +1,125 @@
+package email
+
+import (
+	"bytes"
+	"crypto/rand"
+	"example.com/app/config"
+	"fmt"
+	"mime/multipart"
+	"net/textproto"
+	"strings"
+	"sync"
+	"time"
+)
+
+type Message struct {
+	To      string
+	Subject string
+	Text    string
+	Html    string
+}
+
+// Mailer delivers a message, the backend is picked with MAIL_BACKEND
+type Mailer interface {
+	Send(msg *Message) error
+}
+
+var mailer Mailer
+var once sync.Once
+
+// GetMailer creates the configured mailer once, MAIL_BACKEND is smtp, sendgrid or file (the default)
+func GetMailer() Mailer {
+	once.Do(func() {
+		switch strings.ToLower(config.Config("MAIL_BACKEND")) {
+		case "smtp":
+			mailer = NewSMTPMailer()
+		case "sendgrid":
+			mailer = NewSendGridMailer()
+		default:
+			mailer = NewFileMailer()
+		}
+	})
+	return mailer
+}
+
+// Send delivers msg in the background, a failed email never fails the request that sent it
+func Send(msg *Message, err error) {
+	if err != nil {
+		fmt.Println("Error building email...", err)
+		return
+	}
+
+	go func() {
+		err := GetMailer().Send(msg)
+		if err != nil {
+			fmt.Println("Error sending email...", err)
+			return
+		}
+	}()
+}
+
+func sender() string {
+	from := config.Config("MAIL_FROM")
+
+	if from == "" {
+		return "no-reply@example.com"
+	}
+
+	return from
+}
+
+// buildMIME renders msg as a multipart/alternative email with a text and an HTML part
+func buildMIME(from string, msg *Message) ([]byte, error) {
+	var body bytes.Buffer
+	w := multipart.NewWriter(&body)
+
+	parts := []struct {
+		contentType string
+		content     string
+	}{
+		{"text/plain; charset=UTF-8", msg.Text},
+		{"text/html; charset=UTF-8", msg.Html},
+	}
+
+	for _, p := range parts {
+		header := textproto.MIMEHeader{}
+		header.Set("Content-Type", p.contentType)
+		header.Set("Content-Transfer-Encoding", "8bit")
+
+		pw, err := w.CreatePart(header)
+
+		if err != nil {
+			return nil, err
+		}
+
+		_, err = pw.Write([]byte(p.content))
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err := w.Close()
+
+	if err != nil {
+		return nil, err
+	}
+
+	var b bytes.Buffer
+	b.WriteString("From: " + from + "\r\n")
+	b.WriteString("To: " + msg.To + "\r\n")
+	b.WriteString("Subject: " + msg.Subject + "\r\n")
+	b.WriteString("Date: " + time.Now().Format(time.RFC1123Z) + "\r\n")
+	b.WriteString("Message-ID: " + messageId() + "\r\n")
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: multipart/alternative; boundary=" + w.Boundary() + "\r\n\r\n")
+	b.Write(body.Bytes())
+
+	return b.Bytes(), nil
+}
+
+func messageId() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return fmt.Sprintf("<%x@user-service>", b)
+}
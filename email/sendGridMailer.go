@@ -0,0 +1,38 @@
+package email
+
+import (
+	"example.com/app/config"
+	"fmt"
+	"github.com/sendgrid/sendgrid-go"
+	"github.com/sendgrid/sendgrid-go/helpers/mail"
+)
+
+type SendGridMailer struct {
+	client *sendgrid.Client
+	from   string
+}
+
+func (m SendGridMailer) Send(msg *Message) error {
+	from := mail.NewEmail("", m.from)
+	to := mail.NewEmail("", msg.To)
+	message := mail.NewSingleEmail(from, msg.Subject, to, msg.Text, msg.Html)
+
+	response, err := m.client.Send(message)
+
+	if err != nil {
+		return err
+	}
+
+	if response.StatusCode >= 300 {
+		return fmt.Errorf("sendgrid responded with %d: %v", response.StatusCode, response.Body)
+	}
+
+	return nil
+}
+
+func NewSendGridMailer() SendGridMailer {
+	return SendGridMailer{
+		client: sendgrid.NewSendClient(config.Config("SENDGRID_API_KEY")),
+		from:   sender(),
+	}
+}
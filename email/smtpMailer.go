@@ -0,0 +1,46 @@
+package email
+
+import (
+	"example.com/app/config"
+	"net/smtp"
+)
+
+type SMTPMailer struct {
+	host     string
+	port     string
+	username string
+	password string
+	from     string
+}
+
+func (m SMTPMailer) Send(msg *Message) error {
+	b, err := buildMIME(m.from, msg)
+
+	if err != nil {
+		return err
+	}
+
+	var auth smtp.Auth
+
+	if m.username != "" {
+		auth = smtp.PlainAuth("", m.username, m.password, m.host)
+	}
+
+	return smtp.SendMail(m.host+":"+m.port, auth, m.from, []string{msg.To}, b)
+}
+
+func NewSMTPMailer() SMTPMailer {
+	port := config.Config("SMTP_PORT")
+
+	if port == "" {
+		port = "587"
+	}
+
+	return SMTPMailer{
+		host:     config.Config("SMTP_HOST"),
+		port:     port,
+		username: config.Config("SMTP_USERNAME"),
+		password: config.Config("SMTP_PASSWORD"),
+		from:     sender(),
+	}
+}
@@ -0,0 +1,88 @@
+package email
+
+import (
+	"bytes"
+	"embed"
+	"example.com/app/config"
+	htmlTemplate "html/template"
+	"strings"
+	textTemplate "text/template"
+	"time"
+)
+
+//go:embed templates
+var templateFiles embed.FS
+
+var htmlTemplates = htmlTemplate.Must(htmlTemplate.ParseFS(templateFiles, "templates/*.html"))
+var textTemplates = textTemplate.Must(textTemplate.ParseFS(templateFiles, "templates/*.txt"))
+
+type templateData struct {
+	Username string
+	Link     string
+	Message  string
+}
+
+// Link absolute link to path on APP_URL
+func Link(path string) string {
+	base := config.Config("APP_URL")
+
+	if base == "" {
+		base = "http://127.0.0.1:8080"
+	}
+
+	return strings.TrimRight(base, "/") + path
+}
+
+func VerificationEmail(to string, username string, code string) (*Message, error) {
+	return render(to, "Verify your email", "verification", templateData{
+		Username: username,
+		Link:     Link("/auth/account/" + code),
+	})
+}
+
+func VerificationReminderEmail(to string, username string, code string, purgeAt time.Time) (*Message, error) {
+	return render(to, "Verify your email to keep your account", "verificationReminder", templateData{
+		Username: username,
+		Link:     Link("/auth/account/" + code),
+		Message:  "Accounts that aren't verified by " + purgeAt.Format(time.RFC1123) + " are deleted.",
+	})
+}
+
+func MagicLinkEmail(to string, username string, token string) (*Message, error) {
+	return render(to, "Your login link", "magicLink", templateData{
+		Username: username,
+		Link:     Link("/auth/magic-link/" + token),
+	})
+}
+
+func PasswordResetEmail(to string, username string, token string) (*Message, error) {
+	return render(to, "Reset your password", "passwordReset", templateData{
+		Username: username,
+		Link:     Link("/auth/reset/" + token),
+	})
+}
+
+func SecurityAlertEmail(to string, username string, subject string, message string) (*Message, error) {
+	return render(to, subject, "securityAlert", templateData{
+		Username: username,
+		Message:  message,
+	})
+}
+
+func render(to string, subject string, name string, data templateData) (*Message, error) {
+	var html bytes.Buffer
+	err := htmlTemplates.ExecuteTemplate(&html, name+".html", data)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var text bytes.Buffer
+	err = textTemplates.ExecuteTemplate(&text, name+".txt", data)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &Message{To: to, Subject: subject, Text: text.String(), Html: html.String()}, nil
+}
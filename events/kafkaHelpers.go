@@ -35,8 +35,7 @@ func PushUserToQueue(message []byte, topic string) error {
 
 func SendKafkaMessage(user *domain.User, eventType int) error {
 	um := new(domain.Message)
-	user.Password = ""
-	um.User = *user
+	um.User = *domain.UserEventViewMapper(user)
 
 	// user created/updated event
 	um.MessageType = eventType
@@ -0,0 +1,55 @@
+package geoip
+
+import (
+	"example.com/app/config"
+	"fmt"
+	"github.com/oschwald/maxminddb-golang"
+	"net"
+	"sync"
+)
+
+// record the part of a GeoLite2/GeoIP2 Country or City record we use
+type record struct {
+	Country struct {
+		IsoCode string `maxminddb:"iso_code"`
+	} `maxminddb:"country"`
+}
+
+var reader *maxminddb.Reader
+var once sync.Once
+
+// Country the ISO 3166 code of the country the ip is in, looked up in the MaxMind database at GEOIP_DATABASE.
+// Empty when no database is configured or the ip isn't in it, lookups are optional everywhere.
+func Country(ip string) string {
+	once.Do(func() {
+		path := config.Config("GEOIP_DATABASE")
+
+		if path == "" {
+			return
+		}
+
+		r, err := maxminddb.Open(path)
+
+		if err != nil {
+			fmt.Println("Error opening GeoIP database...", err)
+			return
+		}
+
+		reader = r
+	})
+
+	parsed := net.ParseIP(ip)
+
+	if reader == nil || parsed == nil {
+		return ""
+	}
+
+	var r record
+	err := reader.Lookup(parsed, &r)
+
+	if err != nil {
+		return ""
+	}
+
+	return r.Country.IsoCode
+}
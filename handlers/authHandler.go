@@ -2,11 +2,17 @@ package handlers
 
 import (
 	"example.com/app/domain"
+	"example.com/app/middleware"
+	"example.com/app/oidc"
 	"example.com/app/services"
+	"example.com/app/util"
 	"fmt"
 	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
 	"golang.org/x/crypto/bcrypt"
 	"strings"
+	"time"
 )
 
 type AuthHandler struct {
@@ -22,30 +28,567 @@ func (ah *AuthHandler) Login(c *fiber.Ctx) error {
 		return c.Status(500).JSON(fiber.Map{"status": "error", "message": "error...", "data": fmt.Sprintf("%v", err)})
 	}
 
-	var auth domain.Authentication
-
-	user, token, err := ah.AuthService.Login(strings.ToLower(details.Email), details.Password, c.IP(), c.IPs())
+	user, tokens, err := ah.AuthService.Login(strings.ToLower(details.Email), details.Password, c.IP(), c.IPs(), c.Get("User-Agent"))
 
 	if err != nil {
 		if err == bcrypt.ErrMismatchedHashAndPassword {
 			return c.Status(401).JSON(fiber.Map{"status": "error", "message": "error...", "data": fmt.Sprintf("%v", err)})
 		}
+		if err == domain.ErrAccountLocked {
+			return c.Status(423).JSON(fiber.Map{"status": "error", "message": "error...", "data": fmt.Sprintf("%v", err)})
+		}
+		if err == domain.ErrTooManyAttempts {
+			return c.Status(429).JSON(fiber.Map{"status": "error", "message": "error...", "data": fmt.Sprintf("%v", err)})
+		}
+		if err == domain.ErrDeviceDenied {
+			return c.Status(403).JSON(fiber.Map{"status": "error", "message": "error...", "data": fmt.Sprintf("%v", err)})
+		}
 		return c.Status(401).JSON(fiber.Map{"status": "error", "message": "error...", "data": fmt.Sprintf("Authentication failure")})
 	}
 
-	signedToken := make([]byte, 0, 100)
-	signedToken = append(signedToken, []byte("Bearer " + token + "|")...)
-	t, err := auth.SignToken([]byte(token))
+	if tokens.MfaToken != "" {
+		return c.Status(200).JSON(fiber.Map{"status": "success", "message": "mfa required", "data": fiber.Map{"mfaToken": tokens.MfaToken}})
+	}
+
+	return loginResponse(c, user, tokens)
+}
+
+func (ah *AuthHandler) MagicLinkQuery(c *fiber.Ctx) error {
+	c.Accepts("application/json")
+	q := new(domain.MagicLinkQuery)
+	err := c.BodyParser(q)
 
 	if err != nil {
 		return c.Status(500).JSON(fiber.Map{"status": "error", "message": "error...", "data": fmt.Sprintf("%v", err)})
 	}
 
-	signedToken = append(signedToken, t...)
+	err = ah.AuthService.MagicLinkQuery(q.Email)
+
+	if err != nil {
+		if err == domain.ErrTooManyRequests {
+			return c.Status(429).JSON(fiber.Map{"status": "error", "message": "error...", "data": fmt.Sprintf("%v", err)})
+		}
+		return c.Status(400).JSON(fiber.Map{"status": "error", "message": "error...", "data": fmt.Sprintf("%v", err)})
+	}
+
+	return c.Status(200).JSON(fiber.Map{"status": "success", "message": "success", "data": "if the email belongs to an account a login link has been sent"})
+}
+
+func (ah *AuthHandler) MagicLinkLogin(c *fiber.Ctx) error {
+	c.Accepts("application/json")
+
+	user, tokens, err := ah.AuthService.MagicLinkLogin(c.Params("token"), c.IP(), c.IPs(), c.Get("User-Agent"))
+
+	if err != nil {
+		if err == domain.ErrAccountLocked {
+			return c.Status(423).JSON(fiber.Map{"status": "error", "message": "error...", "data": fmt.Sprintf("%v", err)})
+		}
+		if err == domain.ErrTooManyAttempts {
+			return c.Status(429).JSON(fiber.Map{"status": "error", "message": "error...", "data": fmt.Sprintf("%v", err)})
+		}
+		return c.Status(401).JSON(fiber.Map{"status": "error", "message": "error...", "data": fmt.Sprintf("%v", err)})
+	}
+
+	if tokens.MfaToken != "" {
+		return c.Status(200).JSON(fiber.Map{"status": "success", "message": "mfa required", "data": fiber.Map{"mfaToken": tokens.MfaToken}})
+	}
+
+	return loginResponse(c, user, tokens)
+}
+
+// oidcBindingCookie ties a login with a provider to the browser that started it, see domain.OidcState
+const oidcBindingCookie = "oidc_binding"
+
+// setOidcBinding the cookie is sent on the provider's redirect to the callback, a top level navigation, so it's Lax
+func setOidcBinding(c *fiber.Ctx, binding string, expires time.Time) {
+	c.Cookie(&fiber.Cookie{
+		Name:     oidcBindingCookie,
+		Value:    binding,
+		Path:     "/auth/oidc",
+		Expires:  expires,
+		Secure:   c.Protocol() == "https",
+		HTTPOnly: true,
+		SameSite: "Lax",
+	})
+}
+
+// OidcLogin sends the browser to the provider's login page
+func (ah *AuthHandler) OidcLogin(c *fiber.Ctx) error {
+	url, binding, err := ah.AuthService.OidcAuthorizationUrl(c.Params("provider"), primitive.NilObjectID)
+
+	if err != nil {
+		if err == oidc.ErrUnknownProvider {
+			return c.Status(404).JSON(fiber.Map{"status": "error", "message": "error...", "data": fmt.Sprintf("%v", err)})
+		}
+		return c.Status(502).JSON(fiber.Map{"status": "error", "message": "error...", "data": fmt.Sprintf("%v", err)})
+	}
+
+	setOidcBinding(c, binding, time.Now().Add(domain.OidcStateExpiration()))
+
+	return c.Redirect(url, 302)
+}
+
+func (ah *AuthHandler) OidcCallback(c *fiber.Ctx) error {
+	if c.Query("error") != "" {
+		return c.Status(401).JSON(fiber.Map{"status": "error", "message": "error...", "data": fmt.Sprintf("%v", c.Query("error"))})
+	}
+
+	binding := c.Cookies(oidcBindingCookie)
+	// the binding is for one flow only
+	setOidcBinding(c, "", time.Unix(0, 0))
+
+	user, tokens, err := ah.AuthService.OidcCallback(c.Params("provider"), c.Query("code"), c.Query("state"), binding, c.IP(), c.IPs(), c.Get("User-Agent"))
+
+	if err != nil {
+		if err == domain.ErrAccountLocked {
+			return c.Status(423).JSON(fiber.Map{"status": "error", "message": "error...", "data": fmt.Sprintf("%v", err)})
+		}
+		return c.Status(401).JSON(fiber.Map{"status": "error", "message": "error...", "data": fmt.Sprintf("%v", err)})
+	}
+
+	// the flow was started to link the provider to a logged in user
+	if tokens == nil {
+		return c.Status(200).JSON(fiber.Map{"status": "success", "message": "provider linked", "data": domain.UserSelfViewMapper(domain.UserDtoMapper(*user))})
+	}
+
+	if tokens.MfaToken != "" {
+		return c.Status(200).JSON(fiber.Map{"status": "success", "message": "mfa required", "data": fiber.Map{"mfaToken": tokens.MfaToken}})
+	}
+
+	return loginResponse(c, user, tokens)
+}
+
+// LinkIdentity the browser can't send the access token through the provider's redirects,
+// so the url is returned and the user is remembered with the state. The browser that opens the url
+// has to make this request too, it gets the binding cookie.
+func (ah *AuthHandler) LinkIdentity(c *fiber.Ctx) error {
+	u := middleware.CurrentUser(c)
+
+	url, binding, err := ah.AuthService.OidcAuthorizationUrl(c.Params("provider"), u.Id)
+
+	if err != nil {
+		if err == oidc.ErrUnknownProvider {
+			return c.Status(404).JSON(fiber.Map{"status": "error", "message": "error...", "data": fmt.Sprintf("%v", err)})
+		}
+		return c.Status(502).JSON(fiber.Map{"status": "error", "message": "error...", "data": fmt.Sprintf("%v", err)})
+	}
+
+	setOidcBinding(c, binding, time.Now().Add(domain.OidcStateExpiration()))
+
+	return c.Status(200).JSON(fiber.Map{"status": "success", "message": "success", "data": fiber.Map{"url": url}})
+}
+
+func (ah *AuthHandler) GetAllIdentities(c *fiber.Ctx) error {
+	u := middleware.CurrentUser(c)
+
+	identities, err := ah.AuthService.GetAllIdentities(u.Id)
+
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"status": "error", "message": "error...", "data": fmt.Sprintf("%v", err)})
+	}
+
+	return c.Status(200).JSON(fiber.Map{"status": "success", "message": "success", "data": identities})
+}
+
+func (ah *AuthHandler) UnlinkIdentity(c *fiber.Ctx) error {
+	u := middleware.CurrentUser(c)
+
+	err := ah.AuthService.UnlinkIdentity(u.Id, c.Params("provider"))
+
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"status": "error", "message": "error...", "data": fmt.Sprintf("%v", err)})
+	}
+
+	return c.Status(200).JSON(fiber.Map{"status": "success", "message": "success", "data": "provider unlinked"})
+}
+
+func (ah *AuthHandler) VerifyMfa(c *fiber.Ctx) error {
+	c.Accepts("application/json")
+	v := new(domain.MfaVerification)
+	err := c.BodyParser(v)
+
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"status": "error", "message": "error...", "data": fmt.Sprintf("%v", err)})
+	}
+
+	user, tokens, err := ah.AuthService.VerifyMfa(v.MfaToken, v.Code, c.IP(), c.IPs(), c.Get("User-Agent"))
+
+	if err != nil {
+		return c.Status(401).JSON(fiber.Map{"status": "error", "message": "error...", "data": fmt.Sprintf("%v", err)})
+	}
+
+	return loginResponse(c, user, tokens)
+}
+
+func (ah *AuthHandler) EnrollMfa(c *fiber.Ctx) error {
+	u := middleware.CurrentUser(c)
+
+	enrollment, err := ah.AuthService.EnrollMfa(u.Id)
+
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"status": "error", "message": "error...", "data": fmt.Sprintf("%v", err)})
+	}
+
+	return c.Status(200).JSON(fiber.Map{"status": "success", "message": "success", "data": enrollment})
+}
+
+func (ah *AuthHandler) ConfirmMfa(c *fiber.Ctx) error {
+	c.Accepts("application/json")
+	u := middleware.CurrentUser(c)
+
+	m := new(domain.MfaCode)
+	err := c.BodyParser(m)
+
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"status": "error", "message": "error...", "data": fmt.Sprintf("%v", err)})
+	}
+
+	codes, err := ah.AuthService.ConfirmMfa(u.Id, m.Code)
+
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"status": "error", "message": "error...", "data": fmt.Sprintf("%v", err)})
+	}
+
+	// recovery codes are only stored hashed, this is the only time they can be shown
+	return c.Status(200).JSON(fiber.Map{"status": "success", "message": "success", "data": fiber.Map{"recoveryCodes": codes}})
+}
+
+func (ah *AuthHandler) DisableMfa(c *fiber.Ctx) error {
+	c.Accepts("application/json")
+	u := middleware.CurrentUser(c)
+
+	m := new(domain.MfaCode)
+	err := c.BodyParser(m)
+
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"status": "error", "message": "error...", "data": fmt.Sprintf("%v", err)})
+	}
+
+	err = ah.AuthService.DisableMfa(u.Id, m.Code)
+
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"status": "error", "message": "error...", "data": fmt.Sprintf("%v", err)})
+	}
+
+	return c.Status(204).JSON(fiber.Map{"status": "success", "message": "success", "data": "success"})
+}
+
+func (ah *AuthHandler) RefreshToken(c *fiber.Ctx) error {
+	c.Accepts("application/json")
+	r := new(domain.RefreshTokenRequest)
+	err := c.BodyParser(r)
+
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"status": "error", "message": "error...", "data": fmt.Sprintf("%v", err)})
+	}
+
+	tokens, err := ah.AuthService.RefreshToken(r.RefreshToken)
+
+	if err != nil {
+		return c.Status(401).JSON(fiber.Map{"status": "error", "message": "error...", "data": fmt.Sprintf("%v", err)})
+	}
+
+	return tokenResponse(c, "success", tokens)
+}
+
+func (ah *AuthHandler) Logout(c *fiber.Ctx) error {
+	u := middleware.CurrentUser(c)
+
+	err := ah.AuthService.Logout(u.SessionId)
+
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"status": "error", "message": "error...", "data": fmt.Sprintf("%v", err)})
+	}
+
+	return c.Status(204).JSON(fiber.Map{"status": "success", "message": "success", "data": "success"})
+}
+
+func (ah *AuthHandler) GetAllSessions(c *fiber.Ctx) error {
+	u := middleware.CurrentUser(c)
+
+	sessions, err := ah.AuthService.GetAllSessions(u.Id, u.SessionId)
+
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"status": "error", "message": "error...", "data": fmt.Sprintf("%v", err)})
+	}
+
+	return c.Status(200).JSON(fiber.Map{"status": "success", "message": "success", "data": sessions})
+}
+
+func (ah *AuthHandler) RevokeSession(c *fiber.Ctx) error {
+	u := middleware.CurrentUser(c)
+
+	id, err := primitive.ObjectIDFromHex(c.Params("id"))
+
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"status": "error", "message": "error...", "data": "invalid session id"})
+	}
+
+	err = ah.AuthService.RevokeSession(u.Id, id)
+
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return c.Status(404).JSON(fiber.Map{"status": "error", "message": "error...", "data": "session not found"})
+		}
+		return c.Status(500).JSON(fiber.Map{"status": "error", "message": "error...", "data": fmt.Sprintf("%v", err)})
+	}
+
+	return c.Status(204).JSON(fiber.Map{"status": "success", "message": "success", "data": "success"})
+}
+
+func (ah *AuthHandler) GetAllDevices(c *fiber.Ctx) error {
+	u := middleware.CurrentUser(c)
+
+	devices, err := ah.AuthService.GetAllDevices(u.Id)
+
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"status": "error", "message": "error...", "data": fmt.Sprintf("%v", err)})
+	}
+
+	return c.Status(200).JSON(fiber.Map{"status": "success", "message": "success", "data": devices})
+}
+
+func (ah *AuthHandler) ConfirmDevice(c *fiber.Ctx) error {
+	u := middleware.CurrentUser(c)
+
+	id, err := primitive.ObjectIDFromHex(c.Params("id"))
+
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"status": "error", "message": "error...", "data": "invalid device id"})
+	}
 
-	c.Set("Authorization", string(signedToken))
+	err = ah.AuthService.ConfirmDevice(u.Id, id)
 
-	return c.Status(200).JSON(fiber.Map{"status": "success", "message": "success", "data": user})
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return c.Status(404).JSON(fiber.Map{"status": "error", "message": "error...", "data": "device not found"})
+		}
+		return c.Status(500).JSON(fiber.Map{"status": "error", "message": "error...", "data": fmt.Sprintf("%v", err)})
+	}
+
+	return c.Status(200).JSON(fiber.Map{"status": "success", "message": "success", "data": "device confirmed"})
+}
+
+func (ah *AuthHandler) DenyDevice(c *fiber.Ctx) error {
+	u := middleware.CurrentUser(c)
+
+	id, err := primitive.ObjectIDFromHex(c.Params("id"))
+
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"status": "error", "message": "error...", "data": "invalid device id"})
+	}
+
+	err = ah.AuthService.DenyDevice(u.Id, id, u.SessionId)
+
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return c.Status(404).JSON(fiber.Map{"status": "error", "message": "error...", "data": "device not found"})
+		}
+		return c.Status(400).JSON(fiber.Map{"status": "error", "message": "error...", "data": fmt.Sprintf("%v", err)})
+	}
+
+	return c.Status(200).JSON(fiber.Map{"status": "success", "message": "success", "data": "device denied and signed out"})
+}
+
+func (ah *AuthHandler) GetSecurityActivity(c *fiber.Ctx) error {
+	u := middleware.CurrentUser(c)
+
+	page, err := ah.AuthService.GetSecurityActivity(u.Id, c.Query("cursor"), c.Query("limit"))
+
+	if err != nil {
+		if err == domain.ErrInvalidCursor {
+			return c.Status(400).JSON(fiber.Map{"status": "error", "message": "error...", "data": fmt.Sprintf("%v", err)})
+		}
+		return c.Status(500).JSON(fiber.Map{"status": "error", "message": "error...", "data": fmt.Sprintf("%v", err)})
+	}
+
+	return c.Status(200).JSON(fiber.Map{"status": "success", "message": "success", "data": page})
+}
+
+func (ah *AuthHandler) CreateApiKey(c *fiber.Ctx) error {
+	c.Accepts("application/json")
+	u := middleware.CurrentUser(c)
+
+	k := new(domain.CreateApiKey)
+	err := c.BodyParser(k)
+
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"status": "error", "message": "error...", "data": fmt.Sprintf("%v", err)})
+	}
+
+	credentials, err := ah.AuthService.CreateApiKey(u.Id, k)
+
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"status": "error", "message": "error...", "data": fmt.Sprintf("%v", err)})
+	}
+
+	return c.Status(201).JSON(fiber.Map{"status": "success", "message": "success", "data": credentials})
+}
+
+func (ah *AuthHandler) GetAllApiKeys(c *fiber.Ctx) error {
+	u := middleware.CurrentUser(c)
+
+	apiKeys, err := ah.AuthService.GetAllApiKeys(u.Id)
+
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"status": "error", "message": "error...", "data": fmt.Sprintf("%v", err)})
+	}
+
+	return c.Status(200).JSON(fiber.Map{"status": "success", "message": "success", "data": apiKeys})
+}
+
+func (ah *AuthHandler) DeleteApiKey(c *fiber.Ctx) error {
+	u := middleware.CurrentUser(c)
+
+	id, err := primitive.ObjectIDFromHex(c.Params("id"))
+
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"status": "error", "message": "error...", "data": "invalid api key id"})
+	}
+
+	err = ah.AuthService.DeleteApiKey(u.Id, id)
+
+	if err != nil {
+		return c.Status(404).JSON(fiber.Map{"status": "error", "message": "error...", "data": fmt.Sprintf("%v", err)})
+	}
+
+	return c.Status(200).JSON(fiber.Map{"status": "success", "message": "success", "data": "api key deleted"})
+}
+
+func (ah *AuthHandler) RevokeOtherSessions(c *fiber.Ctx) error {
+	u := middleware.CurrentUser(c)
+
+	err := ah.AuthService.RevokeOtherSessions(u.Id, u.SessionId)
+
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"status": "error", "message": "error...", "data": fmt.Sprintf("%v", err)})
+	}
+
+	return c.Status(204).JSON(fiber.Map{"status": "success", "message": "success", "data": "success"})
+}
+
+func (ah *AuthHandler) Impersonate(c *fiber.Ctx) error {
+	u := middleware.CurrentUser(c)
+
+	user, tokens, err := ah.AuthService.Impersonate(u, strings.ToLower(c.Params("username")), c.IP())
+
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return c.Status(404).JSON(fiber.Map{"status": "error", "message": "error...", "data": "user not found"})
+		}
+		return c.Status(403).JSON(fiber.Map{"status": "error", "message": "error...", "data": fmt.Sprintf("%v", err)})
+	}
+
+	// the token isn't put in the Authorization header, the admin's own token stays the one the client uses
+	accessToken, err := formatAccessToken(tokens.AccessToken)
+
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"status": "error", "message": "error...", "data": fmt.Sprintf("%v", err)})
+	}
+
+	c.Set("Cache-Control", "no-store")
+
+	return c.Status(200).JSON(fiber.Map{
+		"status": "success",
+		"message": "success",
+		"data": domain.UserSelfViewMapper(domain.UserDtoMapper(*user)),
+		"access_token": accessToken,
+		"token_type": "Bearer",
+		"expires_in": tokens.ExpiresIn,
+	})
+}
+
+func (ah *AuthHandler) EndImpersonation(c *fiber.Ctx) error {
+	u := middleware.CurrentUser(c)
+
+	err := ah.AuthService.EndImpersonation(u, c.IP())
+
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"status": "error", "message": "error...", "data": fmt.Sprintf("%v", err)})
+	}
+
+	return c.Status(200).JSON(fiber.Map{"status": "success", "message": "success", "data": "impersonation ended"})
+}
+
+func (ah *AuthHandler) UnlockAccount(c *fiber.Ctx) error {
+	err := ah.AuthService.UnlockAccount(strings.ToLower(c.Params("username")))
+
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return c.Status(404).JSON(fiber.Map{"status": "error", "message": "error...", "data": "user not found"})
+		}
+		return c.Status(500).JSON(fiber.Map{"status": "error", "message": "error...", "data": fmt.Sprintf("%v", err)})
+	}
+
+	return c.Status(204).JSON(fiber.Map{"status": "success", "message": "success", "data": "success"})
+}
+
+func (ah *AuthHandler) GetJwks(c *fiber.Ctx) error {
+	jwks, err := domain.GetSigningKeys().Jwks()
+
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"status": "error", "message": "error...", "data": fmt.Sprintf("%v", err)})
+	}
+
+	c.Set("Cache-Control", "public, max-age=300")
+
+	return c.Status(200).JSON(jwks)
+}
+
+func (ah *AuthHandler) ChangePassword(c *fiber.Ctx) error {
+	c.Accepts("application/json")
+	u := middleware.CurrentUser(c)
+
+	p := new(domain.ChangePassword)
+	err := c.BodyParser(p)
+
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"status": "error", "message": "error...", "data": fmt.Sprintf("%v", err)})
+	}
+
+	err = ah.AuthService.ChangePassword(u.Id, p.CurrentPassword, p.NewPassword, u.SessionId)
+
+	if err != nil {
+		if policyErr, ok := err.(*domain.PasswordPolicyError); ok {
+			return passwordPolicyResponse(c, policyErr)
+		}
+		if err == domain.ErrWrongPassword {
+			return c.Status(401).JSON(fiber.Map{"status": "error", "message": "error...", "data": fmt.Sprintf("%v", err)})
+		}
+		if err == mongo.ErrNoDocuments {
+			return c.Status(400).JSON(fiber.Map{"status": "error", "message": "error...", "data": fmt.Sprintf("%v", err)})
+		}
+		return c.Status(500).JSON(fiber.Map{"status": "error", "message": "error...", "data": fmt.Sprintf("%v", err)})
+	}
+
+	return c.Status(204).JSON(fiber.Map{"status": "success", "message": "success", "data": "success"})
+}
+
+func (ah *AuthHandler) ChangeEmail(c *fiber.Ctx) error {
+	c.Accepts("application/json")
+	u := middleware.CurrentUser(c)
+
+	e := new(domain.ChangeEmail)
+	err := c.BodyParser(e)
+
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"status": "error", "message": "error...", "data": fmt.Sprintf("%v", err)})
+	}
+
+	if !util.IsEmail(e.Email) {
+		return c.Status(400).JSON(fiber.Map{"status": "error", "message": "error...", "data": fmt.Sprintf("invalid email")})
+	}
+
+	err = ah.AuthService.ChangeEmail(u.Id, e.Email, e.Password)
+
+	if err != nil {
+		if err == domain.ErrWrongPassword {
+			return c.Status(401).JSON(fiber.Map{"status": "error", "message": "error...", "data": fmt.Sprintf("%v", err)})
+		}
+		return c.Status(400).JSON(fiber.Map{"status": "error", "message": "error...", "data": fmt.Sprintf("%v", err)})
+	}
+
+	return c.Status(202).JSON(fiber.Map{"status": "success", "message": "success", "data": "verification code sent to the new email"})
 }
 
 func (ah *AuthHandler) ResetPasswordQuery(c *fiber.Ctx) error {
@@ -80,6 +623,9 @@ func (ah *AuthHandler) ResetPassword(c *fiber.Ctx) error {
 	err = ah.AuthService.ResetPassword(token, p.Password)
 
 	if err != nil {
+		if policyErr, ok := err.(*domain.PasswordPolicyError); ok {
+			return passwordPolicyResponse(c, policyErr)
+		}
 		return c.Status(400).JSON(fiber.Map{"status": "error", "message": "error...", "data": fmt.Sprintf("%v", err)})
 	}
 
@@ -98,4 +644,77 @@ func (ah *AuthHandler) VerifyCode(c *fiber.Ctx) error {
 	}
 
 	return nil
-}
\ No newline at end of file
+}
+
+func (ah *AuthHandler) ResendVerification(c *fiber.Ctx) error {
+	c.Accepts("application/json")
+	r := new(domain.ResendVerification)
+	err := c.BodyParser(r)
+
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"status": "error", "message": "error...", "data": fmt.Sprintf("%v", err)})
+	}
+
+	err = ah.AuthService.ResendVerification(r.Email)
+
+	if err != nil {
+		if err == domain.ErrTooManyRequests {
+			return c.Status(429).JSON(fiber.Map{"status": "error", "message": "error...", "data": fmt.Sprintf("%v", err)})
+		}
+		return c.Status(400).JSON(fiber.Map{"status": "error", "message": "error...", "data": fmt.Sprintf("%v", err)})
+	}
+
+	return c.Status(200).JSON(fiber.Map{"status": "success", "message": "success", "data": "if the email belongs to an unverified account a new link has been sent"})
+}
+
+// passwordPolicyResponse lists every rule the password failed so clients can show them next to the field
+func passwordPolicyResponse(c *fiber.Ctx, err *domain.PasswordPolicyError) error {
+	return c.Status(400).JSON(fiber.Map{"status": "error", "message": "password does not meet the policy", "data": err})
+}
+
+func loginResponse(c *fiber.Ctx, user *domain.UserDto, tokens *domain.AuthTokens) error {
+	return tokenResponse(c, domain.UserSelfViewMapper(domain.UserDtoMapper(*user)), tokens)
+}
+
+// tokenResponse sends the token in the Authorization header and as an OAuth2 style token response (RFC 6749 section 5.1)
+func tokenResponse(c *fiber.Ctx, data interface{}, tokens *domain.AuthTokens) error {
+	accessToken, err := formatAccessToken(tokens.AccessToken)
+
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"status": "error", "message": "error...", "data": fmt.Sprintf("%v", err)})
+	}
+
+	c.Set("Authorization", "Bearer " + accessToken)
+	c.Set("Cache-Control", "no-store")
+
+	return c.Status(200).JSON(fiber.Map{
+		"status": "success",
+		"message": "success",
+		"data": data,
+		"access_token": accessToken,
+		"token_type": "Bearer",
+		"expires_in": tokens.ExpiresIn,
+		"refresh_token": tokens.RefreshToken,
+	})
+}
+
+// formatAccessToken in the legacy format the token is sent as "token|signature", otherwise it's the plain JWT
+func formatAccessToken(token string) (string, error) {
+	if !domain.UseLegacyTokenFormat() {
+		return token, nil
+	}
+
+	var auth domain.Authentication
+
+	signedToken := make([]byte, 0, 100)
+	signedToken = append(signedToken, []byte(token + "|")...)
+	t, err := auth.SignToken([]byte(token))
+
+	if err != nil {
+		return "", err
+	}
+
+	signedToken = append(signedToken, t...)
+
+	return string(signedToken), nil
+}
@@ -0,0 +1,248 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"example.com/app/domain"
+	authHelper "example.com/app/helpers"
+	"example.com/app/middleware"
+	"example.com/app/services"
+	"fmt"
+	"github.com/gofiber/fiber/v2"
+	"net/url"
+	"strings"
+)
+
+// OAuthHandler the endpoints other apps use to log their users in with this service.
+// Discovery, token and userinfo answer in the format of the specs instead of our status/message/data.
+type OAuthHandler struct {
+	OAuthService services.OAuthService
+}
+
+func (oh *OAuthHandler) Discovery(c *fiber.Ctx) error {
+	issuer := domain.OAuthIssuer()
+	keys := domain.GetSigningKeys()
+
+	if keys.PrivateKey == nil {
+		return c.Status(404).JSON(&domain.OAuthError{Code: "not_found", Description: domain.ErrSigningKeyRequired.Error()})
+	}
+
+	return c.Status(200).JSON(fiber.Map{
+		"issuer":                                issuer,
+		"authorization_endpoint":                issuer + "/oauth/authorize",
+		"token_endpoint":                        issuer + "/oauth/token",
+		"userinfo_endpoint":                     issuer + "/oauth/userinfo",
+		"jwks_uri":                              issuer + "/.well-known/jwks.json",
+		"scopes_supported":                      domain.SupportedScopes,
+		"response_types_supported":              []string{"code"},
+		"grant_types_supported":                 []string{"authorization_code"},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": []string{keys.Method.Alg()},
+		"code_challenge_methods_supported":      []string{"S256"},
+		"token_endpoint_auth_methods_supported": []string{"client_secret_basic", "client_secret_post", "none"},
+	})
+}
+
+// StartAuthorization is where the apps send the browser, browsers don't have the user's token so they're sent to
+// the login page of our frontend, which answers the request with Authorize and sends the browser back to the app.
+// Invalid requests are redirected to the app with the error once its redirect uri is known to be valid.
+// Requests with a token are left to Authorize.
+func (oh *OAuthHandler) StartAuthorization(c *fiber.Ctx) error {
+	if c.Get("Authorization") != "" {
+		return c.Next()
+	}
+
+	r := new(domain.AuthorizationRequest)
+	err := c.QueryParser(r)
+
+	if err != nil {
+		return c.Status(400).JSON(&domain.OAuthError{Code: "invalid_request", Description: fmt.Sprintf("%v", err)})
+	}
+
+	result, err := oh.OAuthService.CheckAuthorization(r)
+
+	if err != nil {
+		return c.Status(400).JSON(&domain.OAuthError{Code: "invalid_request", Description: fmt.Sprintf("%v", err)})
+	}
+
+	if result != nil {
+		return c.Redirect(result.RedirectUri, fiber.StatusFound)
+	}
+
+	login, err := domain.OAuthLoginUrl(domain.OAuthIssuer() + c.OriginalURL())
+
+	if err != nil {
+		return c.Status(500).JSON(&domain.OAuthError{Code: "server_error", Description: fmt.Sprintf("%v", err)})
+	}
+
+	return c.Redirect(login, fiber.StatusFound)
+}
+
+// Authorize is called by our own frontend with the user's token, the response says where to send the browser
+// or asks for consent, which is given by sending the same request as a POST with approve
+func (oh *OAuthHandler) Authorize(c *fiber.Ctx) error {
+	u := middleware.CurrentUser(c)
+	r := new(domain.AuthorizationRequest)
+
+	var err error
+
+	if c.Method() == fiber.MethodPost {
+		err = c.BodyParser(r)
+	} else {
+		err = c.QueryParser(r)
+	}
+
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"status": "error", "message": "error...", "data": fmt.Sprintf("%v", err)})
+	}
+
+	result, err := oh.OAuthService.Authorize(u.Id, r)
+
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"status": "error", "message": "error...", "data": fmt.Sprintf("%v", err)})
+	}
+
+	return c.Status(200).JSON(fiber.Map{"status": "success", "message": "success", "data": result})
+}
+
+func (oh *OAuthHandler) Token(c *fiber.Ctx) error {
+	c.Set("Cache-Control", "no-store")
+
+	r := new(domain.TokenRequest)
+	err := c.BodyParser(r)
+
+	if err != nil {
+		return c.Status(400).JSON(&domain.OAuthError{Code: "invalid_request", Description: fmt.Sprintf("%v", err)})
+	}
+
+	// client_secret_basic, the id and secret are form encoded before they're put in the header (RFC 6749 section 2.3.1)
+	if clientId, clientSecret, ok := basicAuth(c.Get("Authorization")); ok {
+		r.ClientId = clientId
+		r.ClientSecret = clientSecret
+	}
+
+	tokens, err := oh.OAuthService.Token(r)
+
+	if err != nil {
+		if oauthErr, ok := err.(*domain.OAuthError); ok {
+			if oauthErr.Code == "invalid_client" {
+				return c.Status(401).JSON(oauthErr)
+			}
+			return c.Status(400).JSON(oauthErr)
+		}
+		return c.Status(500).JSON(&domain.OAuthError{Code: "server_error", Description: fmt.Sprintf("%v", err)})
+	}
+
+	return c.Status(200).JSON(tokens)
+}
+
+func (oh *OAuthHandler) UserInfo(c *fiber.Ctx) error {
+	data, err := authHelper.ExtractData(c.Get("Authorization"))
+
+	if err != nil || len(data) != 1 {
+		c.Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+		return c.Status(401).JSON(&domain.OAuthError{Code: "invalid_token"})
+	}
+
+	claims, err := oh.OAuthService.UserInfo(data[0])
+
+	if err != nil {
+		c.Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+		return c.Status(401).JSON(&domain.OAuthError{Code: "invalid_token", Description: fmt.Sprintf("%v", err)})
+	}
+
+	return c.Status(200).JSON(claims)
+}
+
+func (oh *OAuthHandler) CreateClient(c *fiber.Ctx) error {
+	c.Accepts("application/json")
+	client := new(domain.CreateOAuthClient)
+	err := c.BodyParser(client)
+
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"status": "error", "message": "error...", "data": fmt.Sprintf("%v", err)})
+	}
+
+	credentials, err := oh.OAuthService.CreateClient(client)
+
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"status": "error", "message": "error...", "data": fmt.Sprintf("%v", err)})
+	}
+
+	return c.Status(201).JSON(fiber.Map{"status": "success", "message": "success", "data": credentials})
+}
+
+func (oh *OAuthHandler) GetAllClients(c *fiber.Ctx) error {
+	clients, err := oh.OAuthService.GetAllClients()
+
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"status": "error", "message": "error...", "data": fmt.Sprintf("%v", err)})
+	}
+
+	return c.Status(200).JSON(fiber.Map{"status": "success", "message": "success", "data": clients})
+}
+
+func (oh *OAuthHandler) DeleteClient(c *fiber.Ctx) error {
+	err := oh.OAuthService.DeleteClient(c.Params("clientId"))
+
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"status": "error", "message": "error...", "data": fmt.Sprintf("%v", err)})
+	}
+
+	return c.Status(200).JSON(fiber.Map{"status": "success", "message": "success", "data": "client deleted"})
+}
+
+func (oh *OAuthHandler) GetAllConsents(c *fiber.Ctx) error {
+	u := middleware.CurrentUser(c)
+
+	consents, err := oh.OAuthService.GetAllConsents(u.Id)
+
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"status": "error", "message": "error...", "data": fmt.Sprintf("%v", err)})
+	}
+
+	return c.Status(200).JSON(fiber.Map{"status": "success", "message": "success", "data": consents})
+}
+
+func (oh *OAuthHandler) DeleteConsent(c *fiber.Ctx) error {
+	u := middleware.CurrentUser(c)
+
+	err := oh.OAuthService.DeleteConsent(u.Id, c.Params("clientId"))
+
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"status": "error", "message": "error...", "data": fmt.Sprintf("%v", err)})
+	}
+
+	return c.Status(200).JSON(fiber.Map{"status": "success", "message": "success", "data": "consent revoked"})
+}
+
+func basicAuth(header string) (string, string, bool) {
+	if len(header) < 6 || !strings.EqualFold(header[:6], "Basic ") {
+		return "", "", false
+	}
+
+	b, err := base64.StdEncoding.DecodeString(strings.TrimSpace(header[6:]))
+
+	if err != nil {
+		return "", "", false
+	}
+
+	parts := strings.SplitN(string(b), ":", 2)
+
+	if len(parts) != 2 {
+		return "", "", false
+	}
+
+	clientId, err := url.QueryUnescape(parts[0])
+
+	if err != nil {
+		return "", "", false
+	}
+
+	clientSecret, err := url.QueryUnescape(parts[1])
+
+	if err != nil {
+		return "", "", false
+	}
+
+	return clientId, clientSecret, true
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"example.com/app/cache"
 	"example.com/app/domain"
+	"example.com/app/middleware"
 	"example.com/app/services"
 	"example.com/app/util"
 	"fmt"
@@ -19,20 +20,18 @@ type UserHandler struct {
 }
 
 func (uh *UserHandler) GetAllUsers(c *fiber.Ctx) error {
-	token := c.Get("Authorization")
-	page := c.Query("page", "1")
+	page := new(domain.PageRequest)
+
+	if err := c.QueryParser(page); err != nil {
+		return c.Status(400).JSON(fiber.Map{"status": "error", "message": "error...", "data": fmt.Sprintf("%v", err)})
+	}
 
 	span := opentracing.GlobalTracer().StartSpan("Get All users: GET /users")
 	defer span.Finish()
 
 	ctx := opentracing.ContextWithSpan(context.Background(), span)
 
-	var auth domain.Authentication
-	u, loggedIn, err := auth.IsLoggedIn(token)
-
-	if err != nil || loggedIn == false {
-		return c.Status(401).JSON(fiber.Map{"status": "error", "message": "error...", "data": "Unauthorized user"})
-	}
+	u := middleware.CurrentUser(c)
 
 	rdb := cache.RedisCachePool.Get().(*cache2.Cache)
 	defer cache.RedisCachePool.Put(rdb)
@@ -40,34 +39,114 @@ func (uh *UserHandler) GetAllUsers(c *fiber.Ctx) error {
 	users, err := uh.UserService.GetAllUsers(u.Id, page, ctx, rdb, u.Username, span)
 
 	if err != nil {
-		return c.Status(500).JSON(fiber.Map{"status": "error", "message": "error...", "data": fmt.Sprintf("%v", err)})
+		return userPageError(c, err)
 	}
 
-	return c.Status(200).JSON(fiber.Map{"status": "success", "message": "success", "data": users})
+	return c.Status(200).JSON(fiber.Map{"status": "success", "message": "success", "data": userPage(users)})
 }
 
 func (uh *UserHandler) GetAllBlockedUsers(c *fiber.Ctx) error {
-	token := c.Get("Authorization")
+	page := new(domain.PageRequest)
 
-	var auth domain.Authentication
-	u, loggedIn, err := auth.IsLoggedIn(token)
+	if err := c.QueryParser(page); err != nil {
+		return c.Status(400).JSON(fiber.Map{"status": "error", "message": "error...", "data": fmt.Sprintf("%v", err)})
+	}
 
-	if err != nil || loggedIn == false {
-		return c.Status(401).JSON(fiber.Map{"status": "error", "message": "error...", "data": "Unauthorized user"})
+	u := middleware.CurrentUser(c)
+
+	rdb := cache.RedisCachePool.Get().(*cache2.Cache)
+	defer cache.RedisCachePool.Put(rdb)
+
+	users, err := uh.UserService.GetAllBlockedUsers(u.Id, page, rdb, c.Context(), u.Username)
+
+	if err != nil {
+		return userPageError(c, err)
 	}
 
+	return c.Status(200).JSON(fiber.Map{"status": "success", "message": "success", "data": userPage(users)})
+}
+
+func (uh *UserHandler) GetUserProfile(c *fiber.Ctx) error {
+	u := middleware.CurrentUser(c)
+
 	rdb := cache.RedisCachePool.Get().(*cache2.Cache)
 	defer cache.RedisCachePool.Put(rdb)
 
-	users, err := uh.UserService.GetAllBlockedUsers(u.Id, rdb, c.Context(), u.Username)
+	profile, err := uh.UserService.GetUserProfile(u.Id, u.Username, strings.ToLower(c.Params("username")), rdb, c.Context())
 
 	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return c.Status(404).JSON(fiber.Map{"status": "error", "message": "error...", "data": "user not found"})
+		}
 		return c.Status(500).JSON(fiber.Map{"status": "error", "message": "error...", "data": fmt.Sprintf("%v", err)})
 	}
 
+	return c.Status(200).JSON(fiber.Map{"status": "success", "message": "success", "data": profile})
+}
+
+func (uh *UserHandler) GetFollowers(c *fiber.Ctx) error {
+	return uh.getFollowList(c, domain.FollowListFollowers)
+}
+
+func (uh *UserHandler) GetFollowing(c *fiber.Ctx) error {
+	return uh.getFollowList(c, domain.FollowListFollowing)
+}
+
+func (uh *UserHandler) GetMutuals(c *fiber.Ctx) error {
+	return uh.getFollowList(c, domain.FollowListMutuals)
+}
+
+func (uh *UserHandler) getFollowList(c *fiber.Ctx, list string) error {
+	page := new(domain.PageRequest)
+
+	if err := c.QueryParser(page); err != nil {
+		return c.Status(400).JSON(fiber.Map{"status": "error", "message": "error...", "data": fmt.Sprintf("%v", err)})
+	}
+
+	u := middleware.CurrentUser(c)
+
+	rdb := cache.RedisCachePool.Get().(*cache2.Cache)
+	defer cache.RedisCachePool.Put(rdb)
+
+	users, err := uh.UserService.GetFollowList(u.Username, strings.ToLower(c.Params("username")), list, page, rdb, c.Context())
+
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return c.Status(404).JSON(fiber.Map{"status": "error", "message": "error...", "data": "user not found"})
+		}
+		return userPageError(c, err)
+	}
+
 	return c.Status(200).JSON(fiber.Map{"status": "success", "message": "success", "data": users})
 }
 
+func (uh *UserHandler) SearchUsers(c *fiber.Ctx) error {
+	page := new(domain.PageRequest)
+
+	if err := c.QueryParser(page); err != nil {
+		return c.Status(400).JSON(fiber.Map{"status": "error", "message": "error...", "data": fmt.Sprintf("%v", err)})
+	}
+
+	q, err := domain.SearchQuery(c.Query("q"))
+
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"status": "error", "message": "error...", "data": fmt.Sprintf("%v", err)})
+	}
+
+	u := middleware.CurrentUser(c)
+
+	rdb := cache.RedisCachePool.Get().(*cache2.Cache)
+	defer cache.RedisCachePool.Put(rdb)
+
+	users, err := uh.UserService.SearchUsers(u.Id, u.Username, q, page, rdb, c.Context())
+
+	if err != nil {
+		return userPageError(c, err)
+	}
+
+	return c.Status(200).JSON(fiber.Map{"status": "success", "message": "success", "data": userPage(users)})
+}
+
 func (uh *UserHandler) CreateUser(c *fiber.Ctx) error {
 	c.Accepts("application/json")
 	createUserDto := new(domain.CreateUserDto)
@@ -95,6 +174,10 @@ func (uh *UserHandler) CreateUser(c *fiber.Ctx) error {
 	err = uh.UserService.CreateUser(user)
 	fmt.Println(user)
 
+	if policyErr, ok := err.(*domain.PasswordPolicyError); ok {
+		return passwordPolicyResponse(c, policyErr)
+	}
+
 	if err != nil {
 		return c.Status(409).JSON(fiber.Map{"status": "error", "message": "error...", "data": fmt.Sprintf("%v", err)})
 	}
@@ -104,18 +187,11 @@ func (uh *UserHandler) CreateUser(c *fiber.Ctx) error {
 
 func (uh *UserHandler) UpdateProfileVisibility(c *fiber.Ctx) error {
 	c.Accepts("application/json")
-	token := c.Get("Authorization")
-
-	var auth domain.Authentication
-	u, loggedIn, err := auth.IsLoggedIn(token)
-
-	if err != nil || loggedIn == false {
-		return c.Status(401).JSON(fiber.Map{"status": "error", "message": "error...", "data": "Unauthorized user"})
-	}
+	u := middleware.CurrentUser(c)
 
 	userDto := new(domain.UpdateProfileVisibility)
 
-	err = c.BodyParser(userDto)
+	err := c.BodyParser(userDto)
 
 	if err != nil {
 		return c.Status(400).JSON(fiber.Map{"status": "error", "message": "error...", "data": fmt.Sprintf("%v", err)})
@@ -138,18 +214,11 @@ func (uh *UserHandler) UpdateProfileVisibility(c *fiber.Ctx) error {
 
 func (uh *UserHandler) UpdateDisplayFollowerCount(c *fiber.Ctx) error {
 	c.Accepts("application/json")
-	token := c.Get("Authorization")
-
-	var auth domain.Authentication
-	u, loggedIn, err := auth.IsLoggedIn(token)
-
-	if err != nil || loggedIn == false {
-		return c.Status(401).JSON(fiber.Map{"status": "error", "message": "error...", "data": "Unauthorized user"})
-	}
+	u := middleware.CurrentUser(c)
 
 	userDto := new(domain.UpdateDisplayFollowerCount)
 
-	err = c.BodyParser(userDto)
+	err := c.BodyParser(userDto)
 
 	if err != nil {
 		return c.Status(400).JSON(fiber.Map{"status": "error", "message": "error...", "data": fmt.Sprintf("%v", err)})
@@ -172,18 +241,11 @@ func (uh *UserHandler) UpdateDisplayFollowerCount(c *fiber.Ctx) error {
 
 func (uh *UserHandler) UpdateMessageAcceptance(c *fiber.Ctx) error {
 	c.Accepts("application/json")
-	token := c.Get("Authorization")
-
-	var auth domain.Authentication
-	u, loggedIn, err := auth.IsLoggedIn(token)
-
-	if err != nil || loggedIn == false {
-		return c.Status(401).JSON(fiber.Map{"status": "error", "message": "error...", "data": "Unauthorized user"})
-	}
+	u := middleware.CurrentUser(c)
 
 	userDto := new(domain.UpdateMessageAcceptance)
 
-	err = c.BodyParser(userDto)
+	err := c.BodyParser(userDto)
 
 	if err != nil {
 		return c.Status(400).JSON(fiber.Map{"status": "error", "message": "error...", "data": fmt.Sprintf("%v", err)})
@@ -206,18 +268,11 @@ func (uh *UserHandler) UpdateMessageAcceptance(c *fiber.Ctx) error {
 
 func (uh *UserHandler) UpdateCurrentBadge(c *fiber.Ctx) error {
 	c.Accepts("application/json")
-	token := c.Get("Authorization")
-
-	var auth domain.Authentication
-	u, loggedIn, err := auth.IsLoggedIn(token)
-
-	if err != nil || loggedIn == false {
-		return c.Status(401).JSON(fiber.Map{"status": "error", "message": "error...", "data": "Unauthorized user"})
-	}
+	u := middleware.CurrentUser(c)
 
 	userDto := new(domain.UpdateCurrentBadge)
 
-	err = c.BodyParser(userDto)
+	err := c.BodyParser(userDto)
 
 	if err != nil {
 		return c.Status(400).JSON(fiber.Map{"status": "error", "message": "error...", "data": fmt.Sprintf("%v", err)})
@@ -239,18 +294,11 @@ func (uh *UserHandler) UpdateCurrentBadge(c *fiber.Ctx) error {
 
 func (uh *UserHandler) UpdateProfilePicture(c *fiber.Ctx) error {
 	c.Accepts("application/json")
-	token := c.Get("Authorization")
-
-	var auth domain.Authentication
-	u, loggedIn, err := auth.IsLoggedIn(token)
-
-	if err != nil || loggedIn == false {
-		return c.Status(401).JSON(fiber.Map{"status": "error", "message": "error...", "data": "Unauthorized user"})
-	}
+	u := middleware.CurrentUser(c)
 
 	userDto := new(domain.UpdateProfilePicture)
 
-	err = c.BodyParser(userDto)
+	err := c.BodyParser(userDto)
 
 	if err != nil {
 		return c.Status(400).JSON(fiber.Map{"status": "error", "message": "error...", "data": fmt.Sprintf("%v", err)})
@@ -272,18 +320,11 @@ func (uh *UserHandler) UpdateProfilePicture(c *fiber.Ctx) error {
 
 func (uh *UserHandler) UpdateProfileBackgroundPicture(c *fiber.Ctx) error {
 	c.Accepts("application/json")
-	token := c.Get("Authorization")
-
-	var auth domain.Authentication
-	u, loggedIn, err := auth.IsLoggedIn(token)
-
-	if err != nil || loggedIn == false {
-		return c.Status(401).JSON(fiber.Map{"status": "error", "message": "error...", "data": "Unauthorized user"})
-	}
+	u := middleware.CurrentUser(c)
 
 	userDto := new(domain.UpdateProfileBackgroundPicture)
 
-	err = c.BodyParser(userDto)
+	err := c.BodyParser(userDto)
 
 	if err != nil {
 		return c.Status(400).JSON(fiber.Map{"status": "error", "message": "error...", "data": fmt.Sprintf("%v", err)})
@@ -305,18 +346,11 @@ func (uh *UserHandler) UpdateProfileBackgroundPicture(c *fiber.Ctx) error {
 
 func (uh *UserHandler) UpdateCurrentTagline(c *fiber.Ctx) error {
 	c.Accepts("application/json")
-	token := c.Get("Authorization")
-
-	var auth domain.Authentication
-	u, loggedIn, err := auth.IsLoggedIn(token)
-
-	if err != nil || loggedIn == false {
-		return c.Status(401).JSON(fiber.Map{"status": "error", "message": "error...", "data": "Unauthorized user"})
-	}
+	u := middleware.CurrentUser(c)
 
 	userDto := new(domain.UpdateCurrentTagline)
 
-	err = c.BodyParser(userDto)
+	err := c.BodyParser(userDto)
 
 	if err != nil {
 		return c.Status(400).JSON(fiber.Map{"status": "error", "message": "error...", "data": fmt.Sprintf("%v", err)})
@@ -336,21 +370,50 @@ func (uh *UserHandler) UpdateCurrentTagline(c *fiber.Ctx) error {
 	return c.Status(204).JSON(fiber.Map{"status": "success", "message": "success", "data": "success"})
 }
 
-func (uh *UserHandler) UpdateFlagCount(c *fiber.Ctx) error {
-	username := c.Params("username")
+func (uh *UserHandler) UpdateRole(c *fiber.Ctx) error {
 	c.Accepts("application/json")
-	token := c.Get("Authorization")
+	username := c.Params("username")
+
+	role := new(domain.UpdateRole)
+
+	err := c.BodyParser(role)
+
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"status": "error", "message": "error...", "data": fmt.Sprintf("%v", err)})
+	}
 
-	var auth domain.Authentication
-	u, loggedIn, err := auth.IsLoggedIn(token)
+	if !domain.IsRole(role.Role) {
+		return c.Status(400).JSON(fiber.Map{"status": "error", "message": "error...", "data": fmt.Sprintf("invalid role")})
+	}
 
-	if err != nil || loggedIn == false {
-		return c.Status(401).JSON(fiber.Map{"status": "error", "message": "error...", "data": "Unauthorized user"})
+	for _, permission := range role.Permissions {
+		if !domain.IsPermission(permission) {
+			return c.Status(400).JSON(fiber.Map{"status": "error", "message": "error...", "data": fmt.Sprintf("invalid permission %v", permission)})
+		}
 	}
 
+	rdb := cache.RedisCachePool.Get().(*cache2.Cache)
+	defer cache.RedisCachePool.Put(rdb)
+
+	user, err := uh.UserService.UpdateRole(strings.ToLower(username), role, rdb)
+
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return c.Status(404).JSON(fiber.Map{"status": "error", "message": "error...", "data": fmt.Sprintf("user not found")})
+		}
+		return c.Status(500).JSON(fiber.Map{"status": "error", "message": "error...", "data": fmt.Sprintf("%v", err)})
+	}
+	return c.Status(200).JSON(fiber.Map{"status": "success", "message": "success", "data": domain.UserAdminViewMapper(user)})
+}
+
+func (uh *UserHandler) UpdateFlagCount(c *fiber.Ctx) error {
+	username := c.Params("username")
+	c.Accepts("application/json")
+	u := middleware.CurrentUser(c)
+
 	flag := new(domain.Flag)
 
-	err = c.BodyParser(flag)
+	err := c.BodyParser(flag)
 
 	if err != nil {
 		return c.Status(400).JSON(fiber.Map{"status": "error", "message": "error...", "data": fmt.Sprintf("%v", err)})
@@ -371,19 +434,12 @@ func (uh *UserHandler) UpdateFlagCount(c *fiber.Ctx) error {
 }
 
 func (uh *UserHandler) DeleteByID(c *fiber.Ctx) error {
-	token := c.Get("Authorization")
-
-	var auth domain.Authentication
-	u, loggedIn, err := auth.IsLoggedIn(token)
-
-	if err != nil || loggedIn == false {
-		return c.Status(401).JSON(fiber.Map{"status": "error", "message": "error...", "data": "Unauthorized user"})
-	}
+	u := middleware.CurrentUser(c)
 
 	rdb := cache.RedisCachePool.Get().(*cache2.Cache)
 	defer cache.RedisCachePool.Put(rdb)
 
-	err = uh.UserService.DeleteByID(u.Id, rdb, c.Context(), u.Username)
+	err := uh.UserService.DeleteByID(u.Id, rdb, c.Context(), u.Username)
 
 	if err != nil {
 		if err == mongo.ErrNoDocuments {
@@ -395,21 +451,14 @@ func (uh *UserHandler) DeleteByID(c *fiber.Ctx) error {
 }
 
 func (uh *UserHandler) FollowUser(c *fiber.Ctx) error {
-	token := c.Get("Authorization")
-
-	var auth domain.Authentication
-	u, loggedIn, err := auth.IsLoggedIn(token)
-
-	if err != nil || loggedIn == false {
-		return c.Status(401).JSON(fiber.Map{"status": "error", "message": "error...", "data": "Unauthorized user"})
-	}
+	u := middleware.CurrentUser(c)
 
 	currentUsername := c.Params("username")
 
 	rdb := cache.RedisCachePool.Get().(*cache2.Cache)
 	defer cache.RedisCachePool.Put(rdb)
 
-	err = uh.UserService.FollowUser(strings.ToLower(currentUsername), u.Username, rdb)
+	err := uh.UserService.FollowUser(strings.ToLower(currentUsername), u.Username, rdb)
 
 	if err != nil {
 		if err == mongo.ErrNoDocuments {
@@ -421,21 +470,14 @@ func (uh *UserHandler) FollowUser(c *fiber.Ctx) error {
 }
 
 func (uh *UserHandler) UnfollowUser(c *fiber.Ctx) error {
-	token := c.Get("Authorization")
-
-	var auth domain.Authentication
-	u, loggedIn, err := auth.IsLoggedIn(token)
-
-	if err != nil || loggedIn == false {
-		return c.Status(401).JSON(fiber.Map{"status": "error", "message": "error...", "data": "Unauthorized user"})
-	}
+	u := middleware.CurrentUser(c)
 
 	currentUsername := c.Params("username")
 
 	rdb := cache.RedisCachePool.Get().(*cache2.Cache)
 	defer cache.RedisCachePool.Put(rdb)
 
-	err = uh.UserService.UnfollowUser(strings.ToLower(currentUsername), u.Username, rdb)
+	err := uh.UserService.UnfollowUser(strings.ToLower(currentUsername), u.Username, rdb)
 
 	if err != nil {
 		if err == mongo.ErrNoDocuments {
@@ -448,19 +490,12 @@ func (uh *UserHandler) UnfollowUser(c *fiber.Ctx) error {
 
 func (uh *UserHandler) BlockUser(c *fiber.Ctx) error {
 	username := c.Params("username")
-	token := c.Get("Authorization")
-
-	var auth domain.Authentication
-	u, loggedIn, err := auth.IsLoggedIn(token)
-
-	if err != nil || loggedIn == false {
-		return c.Status(401).JSON(fiber.Map{"status": "error", "message": "error...", "data": "Unauthorized user"})
-	}
+	u := middleware.CurrentUser(c)
 
 	rdb := cache.RedisCachePool.Get().(*cache2.Cache)
 	defer cache.RedisCachePool.Put(rdb)
 
-	err = uh.UserService.BlockUser(u.Id, strings.ToLower(username), rdb, c.Context(), u.Username)
+	err := uh.UserService.BlockUser(u.Id, strings.ToLower(username), rdb, c.Context(), u.Username)
 
 	if err != nil {
 		if err == mongo.ErrNoDocuments {
@@ -473,19 +508,12 @@ func (uh *UserHandler) BlockUser(c *fiber.Ctx) error {
 
 func (uh *UserHandler) UnblockUser(c *fiber.Ctx) error {
 	username := c.Params("username")
-	token := c.Get("Authorization")
-
-	var auth domain.Authentication
-	u, loggedIn, err := auth.IsLoggedIn(token)
-
-	if err != nil || loggedIn == false {
-		return c.Status(401).JSON(fiber.Map{"status": "error", "message": "error...", "data": "Unauthorized user"})
-	}
+	u := middleware.CurrentUser(c)
 
 	rdb := cache.RedisCachePool.Get().(*cache2.Cache)
 	defer cache.RedisCachePool.Put(rdb)
 
-	err = uh.UserService.UnblockUser(u.Id, strings.ToLower(username), rdb, c.Context(), u.Username)
+	err := uh.UserService.UnblockUser(u.Id, strings.ToLower(username), rdb, c.Context(), u.Username)
 
 	if err != nil {
 		if err == mongo.ErrNoDocuments {
@@ -495,3 +523,16 @@ func (uh *UserHandler) UnblockUser(c *fiber.Ctx) error {
 	}
 	return c.Status(204).JSON(fiber.Map{"status": "success", "message": "success", "data": "success"})
 }
+
+// userPage the public view of a page of users
+func userPage(users *domain.UserResponse) *domain.Page {
+	return &domain.Page{Items: domain.ViewUserProfileListMapper(*users.Users), NextCursor: users.NextCursor, HasMore: users.HasMore}
+}
+
+// userPageError a bad cursor or sort is the client's fault
+func userPageError(c *fiber.Ctx, err error) error {
+	if err == domain.ErrInvalidCursor || err == domain.ErrInvalidSort {
+		return c.Status(400).JSON(fiber.Map{"status": "error", "message": "error...", "data": fmt.Sprintf("%v", err)})
+	}
+	return c.Status(500).JSON(fiber.Map{"status": "error", "message": "error...", "data": fmt.Sprintf("%v", err)})
+}
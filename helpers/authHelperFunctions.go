@@ -11,10 +11,16 @@ func ExtractData(token  string) ([]string, error) {
 	}
 	xs := strings.Split(token, " ")
 
-	if len(xs) != 2 {
+	if len(xs) != 2 || !strings.EqualFold(xs[0], "Bearer") {
 		return nil, fmt.Errorf("invalid token provided")
 	}
 
+	// standard tokens are just the JWT, legacy tokens are "token|signature"
 	tokenValue := strings.Split(xs[1], "|")
+
+	if len(tokenValue) > 2 {
+		return nil, fmt.Errorf("invalid token provided")
+	}
+
 	return  tokenValue, nil
 }
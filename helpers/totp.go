@@ -0,0 +1,133 @@
+package helpers
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// TOTP settings from RFC 6238, these are the defaults every authenticator app supports
+const (
+	totpPeriod = 30
+	totpDigits = 6
+	// how many periods before and after the current one are accepted, covers clock drift
+	totpSkew = 1
+)
+
+var base32NoPadding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// Clock the time TOTP codes are checked against, tests swap it for a fixed time
+var Clock = time.Now
+
+func GenerateTOTPSecret() (string, error) {
+	b := make([]byte, 20)
+
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+
+	return base32NoPadding.EncodeToString(b), nil
+}
+
+// TOTPURI builds the otpauth URI that authenticator apps read from a QR code
+func TOTPURI(issuer, account, secret string) string {
+	v := url.Values{}
+	v.Set("secret", secret)
+	v.Set("issuer", issuer)
+	v.Set("algorithm", "SHA1")
+	v.Set("digits", fmt.Sprintf("%d", totpDigits))
+	v.Set("period", fmt.Sprintf("%d", totpPeriod))
+
+	label := url.PathEscape(issuer + ":" + account)
+
+	return "otpauth://totp/" + label + "?" + v.Encode()
+}
+
+// TOTPStep is the RFC 6238 time counter for t
+func TOTPStep(t time.Time) int64 {
+	return t.Unix() / totpPeriod
+}
+
+// TOTPCode generates the code for a time step, this is HOTP from RFC 4226 with the step as the counter
+func TOTPCode(secret string, step int64) (string, error) {
+	key, err := base32NoPadding.DecodeString(strings.ToUpper(secret))
+
+	if err != nil {
+		return "", err
+	}
+
+	msg := make([]byte, 8)
+	binary.BigEndian.PutUint64(msg, uint64(step))
+
+	h := hmac.New(sha1.New, key)
+	_, err = h.Write(msg)
+
+	if err != nil {
+		return "", err
+	}
+
+	sum := h.Sum(nil)
+
+	// dynamic truncation
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	return fmt.Sprintf("%0*d", totpDigits, value%1000000), nil
+}
+
+// ValidateTOTP checks a code against the time t, it returns the matching step so a caller can reject replays
+func ValidateTOTP(secret, code string, t time.Time) (int64, bool) {
+	code = strings.TrimSpace(code)
+
+	if len(code) != totpDigits {
+		return 0, false
+	}
+
+	current := TOTPStep(t)
+
+	for i := -totpSkew; i <= totpSkew; i++ {
+		step := current + int64(i)
+		expected, err := TOTPCode(secret, step)
+
+		if err != nil {
+			return 0, false
+		}
+
+		if hmac.Equal([]byte(expected), []byte(code)) {
+			return step, true
+		}
+	}
+
+	return 0, false
+}
+
+// GenerateRecoveryCodes returns n one time codes formatted as xxxxx-xxxxx
+func GenerateRecoveryCodes(n int) ([]string, error) {
+	codes := make([]string, 0, n)
+
+	for i := 0; i < n; i++ {
+		b := make([]byte, 5)
+
+		_, err := rand.Read(b)
+		if err != nil {
+			return nil, err
+		}
+
+		c := fmt.Sprintf("%x", b)
+		codes = append(codes, c[:5]+"-"+c[5:])
+	}
+
+	return codes, nil
+}
+
+// NormalizeRecoveryCode the form recovery codes are hashed in, users may type them in upper case or with spaces around
+func NormalizeRecoveryCode(code string) string {
+	return strings.ToLower(strings.TrimSpace(code))
+}
@@ -0,0 +1,141 @@
+package helpers
+
+import (
+	"regexp"
+	"testing"
+	"time"
+)
+
+// the SHA1 secret of the RFC 6238 test vectors, "12345678901234567890" in base32
+const rfc6238Secret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+// the RFC 6238 appendix B codes are 8 digits, ours are their last 6
+var rfc6238Vectors = []struct {
+	unix int64
+	code string
+}{
+	{59, "287082"},
+	{1111111109, "081804"},
+	{1111111111, "050471"},
+	{1234567890, "005924"},
+	{2000000000, "279037"},
+	{20000000000, "353130"},
+}
+
+func withClock(t *testing.T, now time.Time) {
+	previous := Clock
+	Clock = func() time.Time { return now }
+	t.Cleanup(func() { Clock = previous })
+}
+
+func TestTOTPCodeMatchesRFC6238(t *testing.T) {
+	for _, v := range rfc6238Vectors {
+		code, err := TOTPCode(rfc6238Secret, TOTPStep(time.Unix(v.unix, 0)))
+
+		if err != nil {
+			t.Fatalf("%v: %v", v.unix, err)
+		}
+
+		if code != v.code {
+			t.Errorf("%v: got %v, want %v", v.unix, code, v.code)
+		}
+	}
+}
+
+func TestValidateTOTP(t *testing.T) {
+	withClock(t, time.Unix(1111111111, 0))
+
+	tests := []struct {
+		name  string
+		code  string
+		valid bool
+	}{
+		{"current step", "050471", true},
+		{"previous step within skew", "081804", true},
+		{"surrounding spaces", " 050471 ", true},
+		{"step outside skew", "005924", false},
+		{"wrong code", "000000", false},
+		{"too short", "05047", false},
+		{"the full 8 digits", "14050471", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			step, valid := ValidateTOTP(rfc6238Secret, tt.code, Clock())
+
+			if valid != tt.valid {
+				t.Fatalf("got valid %v, want %v", valid, tt.valid)
+			}
+
+			if valid && (step < TOTPStep(Clock())-totpSkew || step > TOTPStep(Clock())+totpSkew) {
+				t.Errorf("step %v isn't within the skew of %v", step, TOTPStep(Clock()))
+			}
+		})
+	}
+}
+
+func TestValidateTOTPReturnsTheMatchingStep(t *testing.T) {
+	withClock(t, time.Unix(1111111111, 0))
+
+	// 081804 is the code of the step before, the caller stores the step to reject replays
+	step, valid := ValidateTOTP(rfc6238Secret, "081804", Clock())
+
+	if !valid || step != TOTPStep(time.Unix(1111111109, 0)) {
+		t.Errorf("got step %v valid %v", step, valid)
+	}
+}
+
+func TestValidateTOTPRejectsAnInvalidSecret(t *testing.T) {
+	withClock(t, time.Unix(59, 0))
+
+	if _, valid := ValidateTOTP("not base32!", "287082", Clock()); valid {
+		t.Error("a code was accepted for an invalid secret")
+	}
+}
+
+func TestGenerateRecoveryCodes(t *testing.T) {
+	codes, err := GenerateRecoveryCodes(10)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(codes) != 10 {
+		t.Fatalf("got %v codes, want 10", len(codes))
+	}
+
+	format := regexp.MustCompile(`^[0-9a-f]{5}-[0-9a-f]{5}$`)
+	seen := map[string]bool{}
+
+	for _, c := range codes {
+		if !format.MatchString(c) {
+			t.Errorf("%q isn't formatted as xxxxx-xxxxx", c)
+		}
+
+		if seen[c] {
+			t.Errorf("%q was generated twice", c)
+		}
+		seen[c] = true
+
+		if NormalizeRecoveryCode(c) != c {
+			t.Errorf("%q changes when normalized, it would never match its hash", c)
+		}
+	}
+}
+
+func TestNormalizeRecoveryCode(t *testing.T) {
+	tests := []struct {
+		code string
+		want string
+	}{
+		{"ab12c-3de45", "ab12c-3de45"},
+		{"AB12C-3DE45", "ab12c-3de45"},
+		{"  ab12c-3de45\n", "ab12c-3de45"},
+	}
+
+	for _, tt := range tests {
+		if got := NormalizeRecoveryCode(tt.code); got != tt.want {
+			t.Errorf("NormalizeRecoveryCode(%q) = %q, want %q", tt.code, got, tt.want)
+		}
+	}
+}
@@ -1,7 +1,9 @@
 package main
 
 import (
+	"example.com/app/repo"
 	"example.com/app/router"
+	"example.com/app/services"
 	"fmt"
 	"github.com/opentracing/opentracing-go"
 	"github.com/uber/jaeger-client-go"
@@ -44,6 +46,8 @@ func main() {
 
 	opentracing.SetGlobalTracer(tracer)
 
+	go services.RunVerificationSweep(services.NewAuthService(repo.NewAuthRepoImpl()))
+
 	go func() {
 		_ = <- c
 		fmt.Println("Shutting down...")
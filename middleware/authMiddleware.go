@@ -2,25 +2,127 @@ package middleware
 
 import (
 	"example.com/app/domain"
+	"example.com/app/events"
+	"example.com/app/repo"
 	"fmt"
 	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/utils"
 )
 
+// currentUserKey key the authenticated caller is stored under in fiber.Ctx.Locals
+const currentUserKey = "currentUser"
+
+// authRepo looks up the api keys sent instead of a token and audits impersonated requests
+var authRepo repo.AuthRepo = repo.NewAuthRepoImpl()
+
+// IsLoggedIn checks the token or api key once per request and stores the caller for the handlers, read it with CurrentUser
 func IsLoggedIn(c *fiber.Ctx) error {
 	token := c.Get("Authorization")
 
-	var auth domain.Authentication
-	_, loggedIn, err := auth.IsLoggedIn(token)
+	var u *domain.Authentication
+	var loggedIn bool
+	var err error
+
+	if key, ok := domain.ApiKeyFromHeader(token); ok {
+		u, err = authRepo.ApiKeyLogin(key)
+		loggedIn = err == nil
+	} else {
+		var auth domain.Authentication
+		u, loggedIn, err = auth.IsLoggedIn(token)
+	}
 
 	if err != nil || loggedIn == false {
 		return c.Status(401).JSON(fiber.Map{"status": "error", "message": "error...", "data": fmt.Sprintf("Unauthorized user")})
 	}
 
-	err = c.Next()
+	if !u.AllowsMethod(c.Method()) {
+		return c.Status(403).JSON(fiber.Map{"status": "error", "message": "error...", "data": fmt.Sprintf("api key is missing the read or write scope")})
+	}
 
-	if err != nil {
-		return c.Status(401).JSON(fiber.Map{"status": "error", "message": "error...", "data": fmt.Sprintf("Unauthorized user")})
+	c.Locals(currentUserKey, u)
+
+	if u.IsImpersonated() {
+		return auditImpersonation(c, u)
+	}
+
+	return c.Next()
+}
+
+// auditImpersonation runs the rest of the request and then records it with the admin as the actor
+func auditImpersonation(c *fiber.Ctx, u *domain.Authentication) error {
+	err := c.Next()
+
+	// fiber reuses the request's buffers once it's done, the audit is written after that so the strings are copied
+	audit := &domain.ImpersonationAudit{
+		Action:        domain.ImpersonationRequest,
+		ActorId:       u.Actor.Id,
+		ActorUsername: u.Actor.Username,
+		UserId:        u.Id,
+		Username:      u.Username,
+		SessionId:     u.SessionId,
+		Method:        utils.CopyString(c.Method()),
+		Path:          utils.CopyString(c.Path()),
+		Status:        c.Response().StatusCode(),
+		Ip:            utils.CopyString(c.IP()),
+	}
+
+	// the response is already written, recording it shouldn't hold the request up
+	go func() {
+		err := authRepo.RecordImpersonation(audit)
+		if err != nil {
+			fmt.Println("Error recording impersonation...", err)
+		}
+
+		err = events.SendEventMessage(domain.ImpersonationEvent(audit), 0)
+		if err != nil {
+			fmt.Println("Error publishing...")
+		}
+	}()
+
+	return err
+}
+
+// RequireSession keeps api keys away from routes that manage the account itself, mount it after IsLoggedIn
+func RequireSession(c *fiber.Ctx) error {
+	if !CurrentUser(c).ApiKeyId.IsZero() {
+		return c.Status(403).JSON(fiber.Map{"status": "error", "message": "error...", "data": fmt.Sprintf("api keys can't be used here")})
+	}
+
+	return c.Next()
+}
+
+// BlockImpersonation keeps admins impersonating a user away from what only the user should do, like changing
+// the password or deleting the account, mount it after IsLoggedIn
+func BlockImpersonation(c *fiber.Ctx) error {
+	if CurrentUser(c).IsImpersonated() {
+		return c.Status(403).JSON(fiber.Map{"status": "error", "message": "error...", "data": fmt.Sprintf("%v", domain.ErrImpersonating)})
+	}
+
+	return c.Next()
+}
+
+// CurrentUser the caller stored by IsLoggedIn, only call it from routes behind that middleware
+func CurrentUser(c *fiber.Ctx) *domain.Authentication {
+	u, ok := c.Locals(currentUserKey).(*domain.Authentication)
+
+	if !ok {
+		panic("CurrentUser called on a route without the IsLoggedIn middleware")
 	}
 
-	return nil
+	return u
+}
+
+// RequirePermission only lets callers with every one of the permissions through, mount it after IsLoggedIn
+func RequirePermission(permissions ...string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		u := CurrentUser(c)
+
+		for _, permission := range permissions {
+			if !u.HasPermission(permission) {
+				return c.Status(403).JSON(fiber.Map{"status": "error", "message": "error...", "data": fmt.Sprintf("Forbidden")})
+			}
+		}
+
+		return c.Next()
+	}
 }
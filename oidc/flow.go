@@ -0,0 +1,88 @@
+package oidc
+
+import (
+	"encoding/json"
+	authHelper "example.com/app/helpers"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// AuthCodeURL where to send the user to log in, uses PKCE with the S256 challenge from RFC 7636
+func (p *Provider) AuthCodeURL(state string, nonce string, verifier string) (string, error) {
+	d, err := p.getDiscovery()
+
+	if err != nil {
+		return "", err
+	}
+
+	params := url.Values{}
+	params.Set("response_type", "code")
+	params.Set("client_id", p.ClientId)
+	params.Set("redirect_uri", p.RedirectUrl)
+	params.Set("scope", strings.Join(p.Scopes, " "))
+	params.Set("state", state)
+	params.Set("nonce", nonce)
+	params.Set("code_challenge", authHelper.PKCEChallenge(verifier))
+	params.Set("code_challenge_method", "S256")
+
+	separator := "?"
+
+	if strings.Contains(d.AuthorizationEndpoint, "?") {
+		separator = "&"
+	}
+
+	return d.AuthorizationEndpoint + separator + params.Encode(), nil
+}
+
+type tokenResponse struct {
+	IdToken          string `json:"id_token"`
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description"`
+}
+
+// Exchange trades the code from the callback for the ID token and returns its verified claims
+func (p *Provider) Exchange(code string, verifier string, nonce string) (*Claims, error) {
+	d, err := p.getDiscovery()
+
+	if err != nil {
+		return nil, err
+	}
+
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", p.RedirectUrl)
+	form.Set("client_id", p.ClientId)
+	form.Set("code_verifier", verifier)
+
+	if p.ClientSecret != "" {
+		form.Set("client_secret", p.ClientSecret)
+	}
+
+	res, err := httpClient.PostForm(d.TokenEndpoint, form)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer res.Body.Close()
+
+	t := new(tokenResponse)
+	err = json.NewDecoder(res.Body).Decode(t)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if res.StatusCode != http.StatusOK || t.Error != "" {
+		return nil, fmt.Errorf("provider %v rejected the code: %v %v", p.Name, t.Error, t.ErrorDescription)
+	}
+
+	if t.IdToken == "" {
+		return nil, fmt.Errorf("provider %v returned no id token", p.Name)
+	}
+
+	return p.verifyIdToken(t.IdToken, nonce)
+}
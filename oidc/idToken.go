@@ -0,0 +1,169 @@
+package oidc
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	authHelper "example.com/app/helpers"
+	"fmt"
+	"github.com/dgrijalva/jwt-go"
+)
+
+// Claims what we use from a verified ID token
+type Claims struct {
+	Subject           string
+	Email             string
+	EmailVerified     bool
+	PreferredUsername string
+	Name              string
+}
+
+type jwks struct {
+	Keys []authHelper.JWK `json:"keys"`
+}
+
+func (p *Provider) verifyIdToken(raw string, nonce string) (*Claims, error) {
+	d, err := p.getDiscovery()
+
+	if err != nil {
+		return nil, err
+	}
+
+	// MapClaims.Valid checks exp, iat and nbf
+	token, err := jwt.ParseWithClaims(raw, jwt.MapClaims{}, p.verificationKey)
+
+	if err != nil || !token.Valid {
+		return nil, fmt.Errorf("invalid id token")
+	}
+
+	claims := token.Claims.(jwt.MapClaims)
+
+	if iss, _ := claims["iss"].(string); iss != d.Issuer {
+		return nil, fmt.Errorf("id token has the wrong issuer")
+	}
+
+	if !hasAudience(claims["aud"], p.ClientId) {
+		return nil, fmt.Errorf("id token has the wrong audience")
+	}
+
+	// the nonce ties the token to the login we started, a token from another login can't be replayed here
+	if n, _ := claims["nonce"].(string); n != nonce {
+		return nil, fmt.Errorf("id token has the wrong nonce")
+	}
+
+	c := new(Claims)
+	c.Subject, _ = claims["sub"].(string)
+	c.Email, _ = claims["email"].(string)
+	c.PreferredUsername, _ = claims["preferred_username"].(string)
+	c.Name, _ = claims["name"].(string)
+
+	// some providers send email_verified as a string
+	switch v := claims["email_verified"].(type) {
+	case bool:
+		c.EmailVerified = v
+	case string:
+		c.EmailVerified = v == "true"
+	}
+
+	if c.Subject == "" {
+		return nil, fmt.Errorf("id token has no subject")
+	}
+
+	return c, nil
+}
+
+// hasAudience aud is a string or an array of strings
+func hasAudience(aud interface{}, clientId string) bool {
+	switch v := aud.(type) {
+	case string:
+		return v == clientId
+	case []interface{}:
+		for _, a := range v {
+			if s, ok := a.(string); ok && s == clientId {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// verificationKey is the jwt.Keyfunc for ID tokens, the keys are fetched again once when the kid is unknown
+// so the provider can rotate its keys
+func (p *Provider) verificationKey(t *jwt.Token) (interface{}, error) {
+	kid, _ := t.Header["kid"].(string)
+
+	key, err := p.key(kid, false)
+
+	if err != nil {
+		key, err = p.key(kid, true)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	// the alg has to match the key, otherwise a public key could be used as an HMAC secret
+	switch key.(type) {
+	case *rsa.PublicKey:
+		if _, ok := t.Method.(*jwt.SigningMethodRSA); ok {
+			return key, nil
+		}
+	case *ecdsa.PublicKey:
+		if _, ok := t.Method.(*jwt.SigningMethodECDSA); ok {
+			return key, nil
+		}
+	case ed25519.PublicKey:
+		if t.Method == authHelper.SigningMethodEd25519 {
+			return key, nil
+		}
+	}
+
+	return nil, fmt.Errorf("unexpected signing method")
+}
+
+func (p *Provider) key(kid string, refresh bool) (crypto.PublicKey, error) {
+	d, err := p.getDiscovery()
+
+	if err != nil {
+		return nil, err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.keys == nil || refresh {
+		set := new(jwks)
+		err := getJSON(d.JwksUri, set)
+
+		if err != nil {
+			return nil, err
+		}
+
+		keys := map[string]crypto.PublicKey{}
+
+		for i := range set.Keys {
+			if set.Keys[i].Use != "" && set.Keys[i].Use != "sig" {
+				continue
+			}
+
+			key, err := authHelper.JWKToPublicKey(&set.Keys[i])
+
+			if err != nil {
+				continue
+			}
+
+			keys[set.Keys[i].Kid] = key
+		}
+
+		p.keys = keys
+	}
+
+	key, ok := p.keys[kid]
+
+	if !ok {
+		return nil, fmt.Errorf("unknown key id")
+	}
+
+	return key, nil
+}
@@ -0,0 +1,141 @@
+package oidc
+
+import (
+	"crypto"
+	"encoding/json"
+	"example.com/app/config"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Provider an OpenID Connect provider users can log in with, configured with OIDC_PROVIDERS=name,name and
+// OIDC_<NAME>_ISSUER, OIDC_<NAME>_CLIENT_ID, OIDC_<NAME>_CLIENT_SECRET, OIDC_<NAME>_REDIRECT_URL and OIDC_<NAME>_SCOPES
+type Provider struct {
+	Name         string
+	Issuer       string
+	ClientId     string
+	ClientSecret string
+	RedirectUrl  string
+	Scopes       []string
+
+	mu        sync.Mutex
+	discovery *discovery
+	keys      map[string]crypto.PublicKey
+}
+
+// discovery the parts of the provider's /.well-known/openid-configuration we use
+type discovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JwksUri               string `json:"jwks_uri"`
+}
+
+var ErrUnknownProvider = fmt.Errorf("unknown provider")
+
+var httpClient = &http.Client{Timeout: 10 * time.Second}
+
+var providers map[string]*Provider
+var once sync.Once
+
+func GetProvider(name string) (*Provider, error) {
+	once.Do(func() {
+		providers = loadProviders()
+	})
+
+	p, ok := providers[name]
+
+	if !ok {
+		return nil, ErrUnknownProvider
+	}
+
+	return p, nil
+}
+
+func loadProviders() map[string]*Provider {
+	loaded := map[string]*Provider{}
+
+	for _, name := range strings.Split(config.Config("OIDC_PROVIDERS"), ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+
+		if name == "" {
+			continue
+		}
+
+		prefix := "OIDC_" + strings.ToUpper(name) + "_"
+
+		redirectUrl := config.Config(prefix + "REDIRECT_URL")
+
+		if redirectUrl == "" {
+			base := config.Config("APP_URL")
+
+			if base == "" {
+				base = "http://127.0.0.1:8080"
+			}
+
+			redirectUrl = strings.TrimRight(base, "/") + "/auth/oidc/" + name + "/callback"
+		}
+
+		scopes := strings.Fields(config.Config(prefix + "SCOPES"))
+
+		if len(scopes) == 0 {
+			scopes = []string{"openid", "email", "profile"}
+		}
+
+		loaded[name] = &Provider{
+			Name:         name,
+			Issuer:       strings.TrimRight(config.Config(prefix+"ISSUER"), "/"),
+			ClientId:     config.Config(prefix + "CLIENT_ID"),
+			ClientSecret: config.Config(prefix + "CLIENT_SECRET"),
+			RedirectUrl:  redirectUrl,
+			Scopes:       scopes,
+		}
+	}
+
+	return loaded
+}
+
+// getDiscovery fetches the provider's metadata the first time it's needed, a failed fetch is tried again next time
+func (p *Provider) getDiscovery() (*discovery, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.discovery != nil {
+		return p.discovery, nil
+	}
+
+	d := new(discovery)
+	err := getJSON(p.Issuer+"/.well-known/openid-configuration", d)
+
+	if err != nil {
+		return nil, err
+	}
+
+	// the issuer has to be the one we were configured with, otherwise another issuer's tokens would be accepted
+	if strings.TrimRight(d.Issuer, "/") != p.Issuer {
+		return nil, fmt.Errorf("provider %v returned issuer %v", p.Name, d.Issuer)
+	}
+
+	p.discovery = d
+
+	return d, nil
+}
+
+func getJSON(url string, v interface{}) error {
+	res, err := httpClient.Get(url)
+
+	if err != nil {
+		return err
+	}
+
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("%v responded with %d", url, res.StatusCode)
+	}
+
+	return json.NewDecoder(res.Body).Decode(v)
+}
@@ -0,0 +1,259 @@
+package oidc
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	authHelper "example.com/app/helpers"
+	"github.com/dgrijalva/jwt-go"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// stubProvider a local OpenID Connect provider, it issues an ID token for whatever code it's given
+// as long as the PKCE verifier matches the challenge of the authorization request
+type stubProvider struct {
+	server    *httptest.Server
+	key       *rsa.PrivateKey
+	kid       string
+	challenge string
+	// claims of the next ID token
+	claims jwt.MapClaims
+	// issuer the discovery document claims, the server's url when empty
+	issuer string
+}
+
+func newStubProvider(t *testing.T) *stubProvider {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := &stubProvider{key: key, kid: "stub-key"}
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		issuer := s.issuer
+
+		if issuer == "" {
+			issuer = s.server.URL
+		}
+
+		_ = json.NewEncoder(w).Encode(&discovery{
+			Issuer:                issuer,
+			AuthorizationEndpoint: s.server.URL + "/authorize",
+			TokenEndpoint:         s.server.URL + "/token",
+			JwksUri:               s.server.URL + "/jwks",
+		})
+	})
+
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		jwk, err := authHelper.PublicKeyToJWK(s.kid, s.key.Public())
+
+		if err != nil {
+			w.WriteHeader(500)
+			return
+		}
+
+		_ = json.NewEncoder(w).Encode(&jwks{Keys: []authHelper.JWK{*jwk}})
+	})
+
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		if r.FormValue("grant_type") != "authorization_code" || r.FormValue("client_id") != "client" ||
+			!authHelper.VerifyPKCE(r.FormValue("code_verifier"), s.challenge) {
+			w.WriteHeader(400)
+			_ = json.NewEncoder(w).Encode(&tokenResponse{Error: "invalid_grant"})
+			return
+		}
+
+		token := jwt.NewWithClaims(jwt.SigningMethodRS256, s.claims)
+		token.Header["kid"] = s.kid
+		idToken, err := token.SignedString(s.key)
+
+		if err != nil {
+			w.WriteHeader(500)
+			return
+		}
+
+		_ = json.NewEncoder(w).Encode(&tokenResponse{IdToken: idToken})
+	})
+
+	s.server = httptest.NewServer(mux)
+	t.Cleanup(s.server.Close)
+
+	return s
+}
+
+func (s *stubProvider) provider() *Provider {
+	return &Provider{
+		Name:        "stub",
+		Issuer:      s.server.URL,
+		ClientId:    "client",
+		RedirectUrl: "http://127.0.0.1:8080/auth/oidc/stub/callback",
+		Scopes:      []string{"openid", "email", "profile"},
+	}
+}
+
+// authorize what the browser does at the provider, the challenge is remembered for the token request
+func (s *stubProvider) authorize(t *testing.T, p *Provider, state string, nonce string, verifier string) {
+	authUrl, err := p.AuthCodeURL(state, nonce, verifier)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	u, err := url.Parse(authUrl)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	q := u.Query()
+
+	if !strings.HasPrefix(authUrl, s.server.URL+"/authorize?") || q.Get("state") != state || q.Get("nonce") != nonce ||
+		q.Get("client_id") != "client" || q.Get("redirect_uri") != p.RedirectUrl || q.Get("code_challenge_method") != "S256" {
+		t.Fatalf("unexpected authorization url %v", authUrl)
+	}
+
+	s.challenge = q.Get("code_challenge")
+}
+
+func (s *stubProvider) idTokenClaims(nonce string) jwt.MapClaims {
+	return jwt.MapClaims{
+		"iss":                s.server.URL,
+		"aud":                "client",
+		"sub":                "subject-1",
+		"nonce":              nonce,
+		"email":              "jdoe@example.com",
+		"email_verified":     "true",
+		"preferred_username": "jdoe",
+		"name":               "John Doe",
+		"iat":                time.Now().Unix(),
+		"exp":                time.Now().Add(time.Minute).Unix(),
+	}
+}
+
+func TestExchangeReturnsTheVerifiedClaims(t *testing.T) {
+	s := newStubProvider(t)
+	p := s.provider()
+
+	s.authorize(t, p, "state", "nonce", "verifier-0123456789-0123456789-0123456789")
+	s.claims = s.idTokenClaims("nonce")
+
+	claims, err := p.Exchange("code", "verifier-0123456789-0123456789-0123456789", "nonce")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := Claims{Subject: "subject-1", Email: "jdoe@example.com", EmailVerified: true, PreferredUsername: "jdoe", Name: "John Doe"}
+
+	if *claims != want {
+		t.Errorf("got %+v, want %+v", *claims, want)
+	}
+}
+
+func TestExchangeRejects(t *testing.T) {
+	verifier := "verifier-0123456789-0123456789-0123456789"
+
+	tests := []struct {
+		name     string
+		verifier string
+		claims   func(s *stubProvider, c jwt.MapClaims)
+	}{
+		{"a verifier that doesn't match the challenge", "another-verifier-0123456789-0123456789", nil},
+		{"another login's nonce", verifier, func(s *stubProvider, c jwt.MapClaims) { c["nonce"] = "other" }},
+		{"another client's token", verifier, func(s *stubProvider, c jwt.MapClaims) { c["aud"] = "other" }},
+		{"another issuer's token", verifier, func(s *stubProvider, c jwt.MapClaims) { c["iss"] = "https://evil.example.com" }},
+		{"an expired token", verifier, func(s *stubProvider, c jwt.MapClaims) { c["exp"] = time.Now().Add(-time.Minute).Unix() }},
+		{"a token without a subject", verifier, func(s *stubProvider, c jwt.MapClaims) { delete(c, "sub") }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newStubProvider(t)
+			p := s.provider()
+
+			s.authorize(t, p, "state", "nonce", verifier)
+			s.claims = s.idTokenClaims("nonce")
+
+			if tt.claims != nil {
+				tt.claims(s, s.claims)
+			}
+
+			if _, err := p.Exchange("code", tt.verifier, "nonce"); err == nil {
+				t.Error("the exchange succeeded")
+			}
+		})
+	}
+}
+
+func TestExchangeRefetchesTheKeysWhenTheProviderRotates(t *testing.T) {
+	s := newStubProvider(t)
+	p := s.provider()
+
+	// the old key is cached
+	if _, err := p.key(s.kid, false); err != nil {
+		t.Fatal(err)
+	}
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s.key, s.kid = key, "stub-key-2"
+
+	s.authorize(t, p, "state", "nonce", "verifier-0123456789-0123456789-0123456789")
+	s.claims = s.idTokenClaims("nonce")
+
+	if _, err := p.Exchange("code", "verifier-0123456789-0123456789-0123456789", "nonce"); err != nil {
+		t.Fatalf("the token of the new key was refused: %v", err)
+	}
+
+	if _, ok := p.keys["stub-key"]; ok {
+		t.Error("the old key is still cached after the provider stopped publishing it")
+	}
+}
+
+func TestExchangeRejectsTokensSignedWithAnotherKey(t *testing.T) {
+	s := newStubProvider(t)
+	p := s.provider()
+
+	s.authorize(t, p, "state", "nonce", "verifier-0123456789-0123456789-0123456789")
+	s.claims = s.idTokenClaims("nonce")
+
+	// the token is signed with a key the provider doesn't publish, under the kid of one it does
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, s.claims)
+	token.Header["kid"] = s.kid
+	forged, err := token.SignedString(key)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := p.verifyIdToken(forged, "nonce"); err == nil {
+		t.Error("a token signed with another key was accepted")
+	}
+}
+
+func TestDiscoveryMustMatchTheConfiguredIssuer(t *testing.T) {
+	s := newStubProvider(t)
+	s.issuer = "https://evil.example.com"
+
+	if _, err := s.provider().AuthCodeURL("state", "nonce", "verifier"); err == nil {
+		t.Error("a provider claiming another issuer was used")
+	}
+}
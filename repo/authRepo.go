@@ -1,11 +1,47 @@
 package repo
 
-import "example.com/app/domain"
+import (
+	"example.com/app/domain"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
 
 type AuthRepo interface {
-	Login(username string, password string, ip string, ips []string) (*domain.UserDto, string, error)
+	Login(username string, password string, ip string, ips []string, userAgent string) (*domain.UserDto, *domain.AuthTokens, error)
+	MagicLinkQuery(email string) error
+	MagicLinkLogin(token string, ip string, ips []string, userAgent string) (*domain.UserDto, *domain.AuthTokens, error)
+	OidcAuthorizationUrl(provider string, linkUserId primitive.ObjectID) (string, string, error)
+	OidcCallback(provider string, code string, state string, binding string, ip string, ips []string, userAgent string) (*domain.UserDto, *domain.AuthTokens, error)
+	FindAllIdentities(userId primitive.ObjectID) (*[]domain.Identity, error)
+	UnlinkIdentity(userId primitive.ObjectID, provider string) error
+	ApiKeyLogin(key string) (*domain.Authentication, error)
+	CreateApiKey(userId primitive.ObjectID, apiKey *domain.CreateApiKey) (*domain.ApiKeyCredentials, error)
+	FindAllApiKeys(userId primitive.ObjectID) (*[]domain.ApiKey, error)
+	DeleteApiKey(userId primitive.ObjectID, id primitive.ObjectID) error
+	RefreshToken(token string) (*domain.AuthTokens, error)
+	Logout(sessionId string) error
+	FindAllSessions(userId primitive.ObjectID, currentSessionId string) (*[]domain.Session, error)
+	RevokeSession(userId primitive.ObjectID, id primitive.ObjectID) error
+	RevokeOtherSessions(userId primitive.ObjectID, currentSessionId string) error
+	RevokeAllSessions(userId primitive.ObjectID) error
+	RecordImpersonation(audit *domain.ImpersonationAudit) error
+	FindAllDevices(userId primitive.ObjectID) (*[]domain.Device, error)
+	ConfirmDevice(userId primitive.ObjectID, id primitive.ObjectID) error
+	DenyDevice(userId primitive.ObjectID, id primitive.ObjectID, currentSessionId string) error
+	FindSecurityActivity(userId primitive.ObjectID, cursor string, limit int64) (*domain.Page, error)
+	EnrollMfa(userId primitive.ObjectID) (*domain.MfaEnrollment, error)
+	ConfirmMfa(userId primitive.ObjectID, code string) ([]string, error)
+	DisableMfa(userId primitive.ObjectID, code string) error
+	Impersonate(actor *domain.Authentication, username string, ip string) (*domain.UserDto, *domain.AuthTokens, error)
+	EndImpersonation(u *domain.Authentication, ip string) error
+	UnlockAccount(username string) error
+	VerifyMfa(mfaToken string, code string, ip string, ips []string, userAgent string) (*domain.UserDto, *domain.AuthTokens, error)
+	ChangePassword(id primitive.ObjectID, currentPassword string, password string, currentSessionId string) error
+	ChangeEmail(id primitive.ObjectID, email string, password string) error
 	ResetPassword(token, password string) error
 	ResetPasswordQuery(email string) error
 	VerifyCode(code string) error
+	ResendVerification(email string) error
+	RemindUnverifiedUsers() error
+	PurgeUnverifiedUsers() error
 }
 
@@ -2,40 +2,56 @@ package repo
 
 import (
 	"context"
+	"crypto/rand"
+	"encoding/json"
+	"example.com/app/cache"
 	"example.com/app/config"
 	"example.com/app/database"
 	"example.com/app/domain"
+	"example.com/app/email"
 	"example.com/app/events"
+	"example.com/app/geoip"
+	"example.com/app/oidc"
+	authHelper "example.com/app/helpers"
 	"example.com/app/util"
 	"fmt"
+	cache2 "github.com/go-redis/cache/v8"
 	"github.com/gofiber/fiber/v2/utils"
 	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
-	"golang.org/x/crypto/bcrypt"
+	"math/big"
+	"regexp"
 	"strconv"
 	"strings"
 	"time"
 )
 
+const recoveryCodeCount = 10
+
 type AuthRepoImpl struct {
 	*domain.User
 }
 
-func(a AuthRepoImpl) Login(username string, password string, ip string, ips []string) (*domain.UserDto, string, error) {
-	var login domain.Authentication
+func(a AuthRepoImpl) Login(username string, password string, ip string, ips []string, userAgent string) (*domain.UserDto, *domain.AuthTokens, error) {
 	var user domain.User
 
 	conn := database.MongoConnectionPool.Get().(*database.Connection)
 	database.MongoConnectionPool.Put(conn)
 
+	if cache.FailedLogins(ipLoginKey(ip)) >= int64(config.ConfigInt("LOGIN_MAX_ATTEMPTS_PER_IP", 20)) {
+		return nil, nil, domain.ErrTooManyAttempts
+	}
+
 	if util.IsEmail(username) {
 		opts := options.FindOne()
 		err := conn.UserCollection.FindOne(context.TODO(), bson.D{{"email",
 			username}},opts).Decode(&user)
 
 		if err != nil {
-			return nil, "", fmt.Errorf("error finding by email")
+			_, _ = cache.RecordFailedLogin(ipLoginKey(ip), loginAttemptWindow())
+			return nil, nil, fmt.Errorf("error finding by email")
 		}
 	} else {
 		opts := options.FindOne()
@@ -43,161 +59,2170 @@ func(a AuthRepoImpl) Login(username string, password string, ip string, ips []st
 			username}},opts).Decode(&user)
 
 		if err != nil {
-			return nil, "", fmt.Errorf("error finding by username")
+			_, _ = cache.RecordFailedLogin(ipLoginKey(ip), loginAttemptWindow())
+			return nil, nil, fmt.Errorf("error finding by username")
 		}
 	}
 
-	err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(password))
-
-	if err != nil {
-		return nil, "", fmt.Errorf("error comparing password")
+	// the lock runs out on its own, a login after that clears it
+	if user.IsLocked && user.LockedUntil > time.Now().Unix() {
+		return nil, nil, domain.ErrAccountLocked
 	}
 
-	token, err := login.GenerateJWT(user)
+	err := domain.CheckPassword(user.Password, password)
 
 	if err != nil {
-		return nil, "", fmt.Errorf("error generating token")
+		a.recordFailedLogin(conn, &user, ip)
+		return nil, nil, fmt.Errorf("error comparing password")
 	}
 
-	userDto := domain.UserMapper(&user)
-
-	go func() {
-		filter := bson.D{{"username", username}}
-		update := bson.D{{"$set", bson.D{{"lastLoginIp", ip}, {"lastLoginIps", ips}}}}
+	if domain.PasswordNeedsRehash(user.Password) {
+		a.rehashPassword(conn, &user, password)
+	}
 
-		_, err := conn.UserCollection.UpdateOne(context.TODO(),
-			filter, update)
+	if user.IsLocked || user.LockCount > 0 || cache.FailedLogins(user.Id.Hex()) > 0 {
+		err = a.clearLock(conn, user.Id)
 
 		if err != nil {
-			panic(err)
+			return nil, nil, err
 		}
-		return
-	}()
+	}
+
+	if user.MfaEnabled {
+		var login domain.Authentication
+		mfaToken, err := login.GenerateMfaPendingJWT(user)
 
-	go func() {
-		event := new(domain.Event)
-		event.Action = "login"
-		event.Target = username
-		event.ResourceId = user.Id
-		event.ActorUsername = username
-		event.Message = username + " has logged in IP: " + ip + "; IPs: " + strings.Join(ips, ", ")
-		err = events.SendEventMessage(event, 0)
 		if err != nil {
-			fmt.Println("Error publishing...")
-			return
+			return nil, nil, fmt.Errorf("error generating token")
 		}
-	}()
 
-	return userDto, token, nil
+		return nil, &domain.AuthTokens{MfaToken: mfaToken}, nil
+	}
+
+	return a.startSession(conn, &user, ip, ips, userAgent, domain.LoginMethodPassword)
+}
+
+// rehashPassword moves a hash made by an older algorithm or with older parameters to the current one, it only
+// replaces the hash it was checked against so a password change at the same time wins. Failing only logs,
+// the login works with the old hash and the next one tries again.
+func(a AuthRepoImpl) rehashPassword(conn *database.Connection, user *domain.User, password string) {
+	hashedPassword, err := domain.HashPassword(password)
+
+	if err != nil {
+		fmt.Println("Error rehashing password...", err)
+		return
+	}
+
+	_, err = conn.UserCollection.UpdateOne(context.TODO(), bson.M{"_id": user.Id, "password": user.Password},
+		bson.M{"$set": bson.M{"password": hashedPassword}})
+
+	if err != nil {
+		fmt.Println("Error rehashing password...", err)
+		return
+	}
+
+	user.Password = hashedPassword
 }
 
-func(a AuthRepoImpl) ResetPasswordQuery(email string) error {
+// MagicLinkQuery emails a single use login link, unknown addresses get no email and no error
+func(a AuthRepoImpl) MagicLinkQuery(address string) error {
+	window := time.Duration(config.ConfigInt("MAGIC_LINK_REQUEST_WINDOW", 60)) * time.Minute
+	count, err := cache.RecordMagicLinkRequest(address, window)
+
+	if err == nil && count > int64(config.ConfigInt("MAGIC_LINK_MAX_REQUESTS", 5)) {
+		return domain.ErrTooManyRequests
+	}
+
 	conn := database.MongoConnectionPool.Get().(*database.Connection)
 	defer database.MongoConnectionPool.Put(conn)
 
 	var user domain.User
-	err := conn.UserCollection.FindOne(context.TODO(), bson.D{{"email", strings.ToLower(email)}}).Decode(&user)
+	err = conn.UserCollection.FindOne(context.TODO(), bson.M{"email": address}).Decode(&user)
 
 	if err != nil {
-		// ErrNoDocuments means that the filter did not match any documents in the collection
 		if err == mongo.ErrNoDocuments {
-			return fmt.Errorf("email %v was not found", email)
+			return nil
 		}
 		return err
 	}
 
-	// logic to send email with JWT
-	if user.TokenHash == "" || user.TokenExpiresAt < time.Now().Unix() {
-		a := new(domain.Authentication)
-		h := utils.UUIDv4()
-		s, err := a.SignToken([]byte(h))
+	token, err := generateSignedToken()
+
+	if err != nil {
+		return err
+	}
+
+	var login domain.Authentication
+	tokenHash, err := login.SignToken([]byte(token))
+
+	if err != nil {
+		return err
+	}
+
+	// only the hash is stored, like refresh tokens, a new link replaces the previous one
+	expiresAt := time.Now().Add(time.Duration(config.ConfigInt("MAGIC_LINK_EXPIRATION", 15)) * time.Minute)
+	update := bson.M{"$set": bson.M{"magicLinkHash": string(tokenHash), "magicLinkExpiresAt": expiresAt.Unix()}}
+
+	_, err = conn.UserCollection.UpdateOne(context.TODO(), bson.M{"_id": user.Id}, update)
+
+	if err != nil {
+		return fmt.Errorf("error processing data")
+	}
+
+	email.Send(email.MagicLinkEmail(user.Email, user.Username, token))
+
+	return nil
+}
+
+// MagicLinkLogin exchanges a login link for the same tokens as Login, users with mfa still have to pass it
+func(a AuthRepoImpl) MagicLinkLogin(token string, ip string, ips []string, userAgent string) (*domain.UserDto, *domain.AuthTokens, error) {
+	var login domain.Authentication
+
+	conn := database.MongoConnectionPool.Get().(*database.Connection)
+	defer database.MongoConnectionPool.Put(conn)
+
+	if cache.FailedLogins(ipLoginKey(ip)) >= int64(config.ConfigInt("LOGIN_MAX_ATTEMPTS_PER_IP", 20)) {
+		return nil, nil, domain.ErrTooManyAttempts
+	}
+
+	tokenHash, err := login.SignToken([]byte(token))
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// clearing the hash in the same query makes the link single use
+	filter := bson.M{"magicLinkHash": string(tokenHash), "magicLinkExpiresAt": bson.M{"$gt": time.Now().Unix()}}
+	update := bson.M{"$set": bson.M{"magicLinkHash": "", "magicLinkExpiresAt": 0}}
+
+	var user domain.User
+	err = conn.UserCollection.FindOneAndUpdate(context.TODO(), filter, update).Decode(&user)
+
+	if err != nil {
+		_, _ = cache.RecordFailedLogin(ipLoginKey(ip), loginAttemptWindow())
+		return nil, nil, fmt.Errorf("invalid or expired login link")
+	}
+
+	if user.IsLocked && user.LockedUntil > time.Now().Unix() {
+		return nil, nil, domain.ErrAccountLocked
+	}
+
+	if user.MfaEnabled {
+		mfaToken, err := login.GenerateMfaPendingJWT(user)
 
 		if err != nil {
-			return err
+			return nil, nil, fmt.Errorf("error generating token")
 		}
 
-		expiration, err := strconv.Atoi(config.Config("PASSWORD_RESET_TOKEN_EXPIRATION"))
+		return nil, &domain.AuthTokens{MfaToken: mfaToken}, nil
+	}
+
+	return a.startSession(conn, &user, ip, ips, userAgent, domain.LoginMethodMagicLink)
+}
+
+// OidcAuthorizationUrl starts a login with provider, with linkUserId set the provider is linked to that user instead.
+// The binding goes in a cookie of the browser, the callback only finishes the flow in that browser.
+func(a AuthRepoImpl) OidcAuthorizationUrl(providerName string, linkUserId primitive.ObjectID) (string, string, error) {
+	provider, err := oidc.GetProvider(providerName)
+
+	if err != nil {
+		return "", "", err
+	}
+
+	var values [4]string
+
+	for i := range values {
+		values[i], err = authHelper.RandomString()
 
 		if err != nil {
-			return err
+			return "", "", err
 		}
+	}
 
-		hash := h + "-" + string(s)
-		user.TokenHash = hash
-		user.TokenExpiresAt = time.Now().Add(time.Duration(expiration) * time.Minute).Unix()
-		ur := new(UserRepoImpl)
-		_, err = ur.UpdateByID(user.Id, &user)
+	state, verifier, nonce, binding := values[0], values[1], values[2], values[3]
+
+	b, err := json.Marshal(&domain.OidcState{Provider: provider.Name, Verifier: verifier, Nonce: nonce, LinkUserId: linkUserId,
+		BindingHash: domain.OidcBindingHash(binding)})
+
+	if err != nil {
+		return "", "", err
 	}
 
-	// send token url in email to user
-	fmt.Println("http://127.0.0.1:8080/auth/reset/" + user.TokenHash)
+	err = cache.SaveOidcState(state, b, domain.OidcStateExpiration())
+
+	if err != nil {
+		return "", "", fmt.Errorf("error processing data")
+	}
 
-	fmt.Println(user.TokenHash)
+	url, err := provider.AuthCodeURL(state, nonce, verifier)
 
-	return nil
+	if err != nil {
+		return "", "", err
+	}
+
+	return url, binding, nil
 }
 
-func(a AuthRepoImpl) ResetPassword(token, password string) error {
+// OidcCallback finishes a login started by OidcAuthorizationUrl, the user is created on their first login.
+// When the flow was started to link the provider no tokens are returned.
+func(a AuthRepoImpl) OidcCallback(providerName string, code string, state string, binding string, ip string, ips []string, userAgent string) (*domain.UserDto, *domain.AuthTokens, error) {
+	b, err := cache.TakeOidcState(state)
+
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid or expired state")
+	}
+
+	s := new(domain.OidcState)
+	err = json.Unmarshal(b, s)
+
+	if err != nil || s.Provider != providerName {
+		return nil, nil, fmt.Errorf("invalid or expired state")
+	}
+
+	// a callback url sent to someone else would log them in as, or link them to, whoever started the flow
+	if !s.MatchesBinding(binding) {
+		return nil, nil, fmt.Errorf("the login was started in another browser")
+	}
+
+	provider, err := oidc.GetProvider(providerName)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	claims, err := provider.Exchange(code, s.Verifier, s.Nonce)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
 	conn := database.MongoConnectionPool.Get().(*database.Connection)
 	defer database.MongoConnectionPool.Put(conn)
 
-	user := new(domain.User)
-	ur := new(UserRepoImpl)
-	err := conn.UserCollection.FindOne(context.TODO(), bson.D{{"tokenHash", token}}).Decode(&user)
+	if !s.LinkUserId.IsZero() {
+		user, err := a.linkIdentity(conn, s.LinkUserId, provider.Name, claims)
+
+		if err != nil {
+			return nil, nil, err
+		}
+
+		return domain.UserMapper(user), nil, nil
+	}
+
+	var user domain.User
+	var identity domain.Identity
+	err = conn.IdentityCollection.FindOne(context.TODO(), bson.M{"provider": provider.Name, "subject": claims.Subject}).Decode(&identity)
+
+	if err == nil {
+		err = conn.UserCollection.FindOne(context.TODO(), bson.M{"_id": identity.UserId}).Decode(&user)
+	} else if err == mongo.ErrNoDocuments {
+		err = a.createOidcUser(conn, &user, provider.Name, claims)
+	}
+
 	if err != nil {
-		// ErrNoDocuments means that the filter did not match any documents in the collection
-		if err == mongo.ErrNoDocuments {
-			return fmt.Errorf("no token found")
+		return nil, nil, err
+	}
+
+	if user.IsLocked && user.LockedUntil > time.Now().Unix() {
+		return nil, nil, domain.ErrAccountLocked
+	}
+
+	if user.MfaEnabled {
+		var login domain.Authentication
+		mfaToken, err := login.GenerateMfaPendingJWT(user)
+
+		if err != nil {
+			return nil, nil, fmt.Errorf("error generating token")
 		}
-		return err
+
+		return nil, &domain.AuthTokens{MfaToken: mfaToken}, nil
 	}
 
-	if user.TokenExpiresAt < time.Now().Unix() {
-		return fmt.Errorf("token has expired")
+	return a.startSession(conn, &user, ip, ips, userAgent, domain.LoginMethodOidc + ":" + provider.Name)
+}
+
+// createOidcUser signs up a user from the provider's claims, accounts are never matched by email because
+// whoever controls the provider account could otherwise take over an existing user
+func(a AuthRepoImpl) createOidcUser(conn *database.Connection, user *domain.User, providerName string, claims *oidc.Claims) error {
+	address := strings.ToLower(claims.Email)
+
+	if !util.IsEmail(address) {
+		return fmt.Errorf("provider did not share an email address")
 	}
 
-	// update password logic
-	err = ur.UpdatePassword(user.Id, password)
+	count, err := conn.UserCollection.CountDocuments(context.TODO(), bson.M{"email": address})
 
 	if err != nil {
-		return err
+		return fmt.Errorf("error processing data")
 	}
 
-	return nil
+	if count > 0 {
+		return fmt.Errorf("an account with this email already exists, log in and link the provider instead")
+	}
+
+	ur := new(UserRepoImpl)
+
+	// another signup can take the username between the check and the insert, so try again with a new one
+	for attempt := 0; attempt < 5; attempt++ {
+		username, err := a.availableUsername(conn, claims)
+
+		if err != nil {
+			return err
+		}
+
+		*user = *util.CreateUser(&domain.CreateUserDto{Username: username, Email: address})
+		user.Following = make([]string, 0, 0)
+		user.Followers = make([]string, 0, 0)
+		user.DisplayFollowerCount = true
+		user.IsVerified = claims.EmailVerified
+
+		if !user.IsVerified {
+			user.VerificationCode, err = generateSignedToken()
+
+			if err != nil {
+				return err
+			}
+
+			user.VerificationCodeExpiresAt = domain.VerificationCodeExpiresAt()
+		}
+
+		err = ur.Create(user)
+
+		if err == nil {
+			break
+		}
+
+		if err != domain.ErrUsernameTaken {
+			return err
+		}
+	}
+
+	if user.Id.IsZero() {
+		return domain.ErrUsernameTaken
+	}
+
+	if !user.IsVerified {
+		email.Send(email.VerificationEmail(user.Email, user.Username, user.VerificationCode))
+	}
+
+	_, err = a.insertIdentity(conn, user.Id, providerName, claims)
+
+	return err
 }
 
-func (a AuthRepoImpl) VerifyCode(code string) error{
-	conn := database.MongoConnectionPool.Get().(*database.Connection)
-	defer database.MongoConnectionPool.Put(conn)
+var usernameCharacters = regexp.MustCompile("[^a-z0-9_.-]+")
+
+// availableUsername the provider's username, the email's local part or the name, with a number added when it's taken
+func(a AuthRepoImpl) availableUsername(conn *database.Connection, claims *oidc.Claims) (string, error) {
+	base := claims.PreferredUsername
+
+	if base == "" {
+		base = strings.Split(claims.Email, "@")[0]
+	}
+
+	if base == "" {
+		base = claims.Name
+	}
+
+	base = usernameCharacters.ReplaceAllString(strings.ToLower(base), "")
+
+	if len(base) > 24 {
+		base = base[:24]
+	}
+
+	if len(base) <= 1 {
+		base = "user"
+	}
+
+	candidate := base
+
+	for attempt := 0; attempt < 10; attempt++ {
+		count, err := conn.UserCollection.CountDocuments(context.TODO(), bson.M{"username": candidate})
+
+		if err != nil {
+			return "", fmt.Errorf("error processing data")
+		}
 
+		if count == 0 {
+			return candidate, nil
+		}
+
+		n, err := rand.Int(rand.Reader, big.NewInt(10000))
+
+		if err != nil {
+			return "", err
+		}
+
+		candidate = fmt.Sprintf("%v%04d", base, n.Int64())
+	}
+
+	return "", domain.ErrUsernameTaken
+}
+
+func(a AuthRepoImpl) linkIdentity(conn *database.Connection, userId primitive.ObjectID, providerName string, claims *oidc.Claims) (*domain.User, error) {
 	var user domain.User
-	ur := new(UserRepoImpl)
-	err := conn.UserCollection.FindOne(context.TODO(), bson.D{{"verificationCode", code}}).Decode(&user)
+	err := conn.UserCollection.FindOne(context.TODO(), bson.M{"_id": userId}).Decode(&user)
 
-	if user.IsVerified {
-		return fmt.Errorf("user email already verified")
+	if err != nil {
+		return nil, fmt.Errorf("user not found")
+	}
+
+	count, err := conn.IdentityCollection.CountDocuments(context.TODO(), bson.M{"userId": userId, "provider": providerName})
+
+	if err != nil {
+		return nil, fmt.Errorf("error processing data")
+	}
+
+	if count > 0 {
+		return nil, fmt.Errorf("provider is already linked")
 	}
 
+	_, err = a.insertIdentity(conn, userId, providerName, claims)
+
 	if err != nil {
-		// ErrNoDocuments means that the filter did not match any documents in the collection
-		if err == mongo.ErrNoDocuments {
-			return fmt.Errorf("no token found")
+		return nil, err
+	}
+
+	sendAuthEvent("identity_linked", &user, user.Username + " linked " + providerName)
+
+	return &user, nil
+}
+
+func(a AuthRepoImpl) insertIdentity(conn *database.Connection, userId primitive.ObjectID, providerName string, claims *oidc.Claims) (*domain.Identity, error) {
+	identity := new(domain.Identity)
+	identity.Id = primitive.NewObjectID()
+	identity.UserId = userId
+	identity.Provider = providerName
+	identity.Subject = claims.Subject
+	identity.Email = strings.ToLower(claims.Email)
+	identity.CreatedAt = time.Now()
+
+	_, err := conn.IdentityCollection.InsertOne(context.TODO(), identity)
+
+	if err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return nil, fmt.Errorf("this %v account is linked to another user", providerName)
 		}
-		return err
+		return nil, fmt.Errorf("error processing data")
 	}
 
+	return identity, nil
+}
+
+func(a AuthRepoImpl) FindAllIdentities(userId primitive.ObjectID) (*[]domain.Identity, error) {
+	conn := database.MongoConnectionPool.Get().(*database.Connection)
+	defer database.MongoConnectionPool.Put(conn)
 
-	u := new(domain.UpdateVerification)
+	cur, err := conn.IdentityCollection.Find(context.TODO(), bson.M{"userId": userId})
 
-	u.IsVerified = true
+	if err != nil {
+		return nil, err
+	}
 
-	err = ur.UpdateVerification(user.Id, u)
+	identities := make([]domain.Identity, 0)
+	err = cur.All(context.TODO(), &identities)
 
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	return nil
+	return &identities, nil
+}
+
+// UnlinkIdentity users without a password keep at least one provider, otherwise they couldn't log in anymore
+func(a AuthRepoImpl) UnlinkIdentity(userId primitive.ObjectID, providerName string) error {
+	conn := database.MongoConnectionPool.Get().(*database.Connection)
+	defer database.MongoConnectionPool.Put(conn)
+
+	var user domain.User
+	err := conn.UserCollection.FindOne(context.TODO(), bson.M{"_id": userId}).Decode(&user)
+
+	if err != nil {
+		return fmt.Errorf("user not found")
+	}
+
+	if user.Password == "" {
+		count, err := conn.IdentityCollection.CountDocuments(context.TODO(), bson.M{"userId": userId})
+
+		if err != nil {
+			return fmt.Errorf("error processing data")
+		}
+
+		if count <= 1 {
+			return fmt.Errorf("set a password before unlinking your last provider")
+		}
+	}
+
+	res, err := conn.IdentityCollection.DeleteOne(context.TODO(), bson.M{"userId": userId, "provider": providerName})
+
+	if err != nil {
+		return fmt.Errorf("error processing data")
+	}
+
+	if res.DeletedCount == 0 {
+		return fmt.Errorf("provider is not linked")
+	}
+
+	sendAuthEvent("identity_unlinked", &user, user.Username + " unlinked " + providerName)
+
+	return nil
+}
+
+// ApiKeyLogin the caller of a request authenticated with key, see domain.ApiKeyAuthentication
+func(a AuthRepoImpl) ApiKeyLogin(key string) (*domain.Authentication, error) {
+	var login domain.Authentication
+
+	keyHash, err := login.SignToken([]byte(key))
+
+	if err != nil {
+		return nil, err
+	}
+
+	conn := database.MongoConnectionPool.Get().(*database.Connection)
+	defer database.MongoConnectionPool.Put(conn)
+
+	var apiKey domain.ApiKey
+	err = conn.ApiKeyCollection.FindOne(context.TODO(), bson.M{"keyHash": string(keyHash)}).Decode(&apiKey)
+
+	if err != nil {
+		return nil, fmt.Errorf("invalid api key")
+	}
+
+	var user domain.User
+	err = conn.UserCollection.FindOne(context.TODO(), bson.M{"_id": apiKey.UserId}).Decode(&user)
+
+	if err != nil {
+		return nil, fmt.Errorf("invalid api key")
+	}
+
+	auth, err := domain.ApiKeyAuthentication(&apiKey, &user)
+
+	if err != nil {
+		return nil, err
+	}
+
+	// writing on every request would be a write per call, a minute is precise enough
+	if time.Since(apiKey.LastUsedAt) > time.Minute {
+		go func() {
+			conn := database.MongoConnectionPool.Get().(*database.Connection)
+			defer database.MongoConnectionPool.Put(conn)
+
+			_, err := conn.ApiKeyCollection.UpdateOne(context.TODO(), bson.M{"_id": apiKey.Id}, bson.M{"$set": bson.M{"lastUsedAt": time.Now()}})
+
+			if err != nil {
+				fmt.Println("Error updating api key...")
+			}
+		}()
+	}
+
+	return auth, nil
+}
+
+func(a AuthRepoImpl) CreateApiKey(userId primitive.ObjectID, k *domain.CreateApiKey) (*domain.ApiKeyCredentials, error) {
+	conn := database.MongoConnectionPool.Get().(*database.Connection)
+	defer database.MongoConnectionPool.Put(conn)
+
+	if k.Name == "" || len(k.Scopes) == 0 {
+		return nil, fmt.Errorf("name and scopes are required")
+	}
+
+	if k.ExpiresInDays < 0 {
+		return nil, fmt.Errorf("expiresInDays can't be negative")
+	}
+
+	var user domain.User
+	err := conn.UserCollection.FindOne(context.TODO(), bson.M{"_id": userId}).Decode(&user)
+
+	if err != nil {
+		return nil, fmt.Errorf("user not found")
+	}
+
+	permissions := domain.EffectivePermissions(user.Role, user.Permissions)
+
+	for _, scope := range k.Scopes {
+		if !domain.IsApiKeyScope(scope) {
+			return nil, fmt.Errorf("unsupported scope %v", scope)
+		}
+
+		// a key can't be given a permission its user doesn't have
+		if domain.IsPermission(scope) && !contains(permissions, scope) {
+			return nil, fmt.Errorf("you don't have the %v permission", scope)
+		}
+	}
+
+	random, err := authHelper.RandomString()
+
+	if err != nil {
+		return nil, err
+	}
+
+	key := domain.ApiKeyPrefix + random
+
+	var login domain.Authentication
+	keyHash, err := login.SignToken([]byte(key))
+
+	if err != nil {
+		return nil, err
+	}
+
+	apiKey := new(domain.ApiKey)
+	apiKey.Id = primitive.NewObjectID()
+	apiKey.UserId = userId
+	apiKey.Name = k.Name
+	apiKey.KeyHash = string(keyHash)
+	apiKey.Scopes = k.Scopes
+	apiKey.CreatedAt = time.Now()
+
+	if k.ExpiresInDays > 0 {
+		apiKey.ExpiresAt = time.Now().AddDate(0, 0, k.ExpiresInDays).Unix()
+	}
+
+	_, err = conn.ApiKeyCollection.InsertOne(context.TODO(), apiKey)
+
+	if err != nil {
+		return nil, fmt.Errorf("error processing data")
+	}
+
+	sendAuthEvent("api_key_created", &user, user.Username + " created the api key " + apiKey.Name)
+
+	return &domain.ApiKeyCredentials{Id: apiKey.Id, Key: key}, nil
+}
+
+func(a AuthRepoImpl) FindAllApiKeys(userId primitive.ObjectID) (*[]domain.ApiKey, error) {
+	conn := database.MongoConnectionPool.Get().(*database.Connection)
+	defer database.MongoConnectionPool.Put(conn)
+
+	cur, err := conn.ApiKeyCollection.Find(context.TODO(), bson.M{"userId": userId})
+
+	if err != nil {
+		return nil, err
+	}
+
+	apiKeys := make([]domain.ApiKey, 0)
+	err = cur.All(context.TODO(), &apiKeys)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &apiKeys, nil
+}
+
+func(a AuthRepoImpl) DeleteApiKey(userId primitive.ObjectID, id primitive.ObjectID) error {
+	conn := database.MongoConnectionPool.Get().(*database.Connection)
+	defer database.MongoConnectionPool.Put(conn)
+
+	res, err := conn.ApiKeyCollection.DeleteOne(context.TODO(), bson.M{"_id": id, "userId": userId})
+
+	if err != nil {
+		return fmt.Errorf("error processing data")
+	}
+
+	if res.DeletedCount == 0 {
+		return fmt.Errorf("api key not found")
+	}
+
+	return nil
+}
+
+func(a AuthRepoImpl) RefreshToken(token string) (*domain.AuthTokens, error) {
+	var login domain.Authentication
+	var refreshToken domain.RefreshToken
+
+	conn := database.MongoConnectionPool.Get().(*database.Connection)
+	defer database.MongoConnectionPool.Put(conn)
+
+	tokenHash, err := login.SignToken([]byte(token))
+
+	if err != nil {
+		return nil, err
+	}
+
+	// marking the token as used in the same query makes it single use
+	filter := bson.M{"tokenHash": string(tokenHash), "isUsed": false, "isRevoked": false}
+	update := bson.M{"$set": bson.M{"isUsed": true}}
+
+	err = conn.RefreshTokenCollection.FindOneAndUpdate(context.TODO(), filter, update).Decode(&refreshToken)
+
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			// a used token being replayed means it was stolen, so the whole session is revoked
+			err = conn.RefreshTokenCollection.FindOne(context.TODO(), bson.M{"tokenHash": string(tokenHash)}).Decode(&refreshToken)
+
+			if err == nil {
+				err = a.Logout(refreshToken.SessionId)
+				if err != nil {
+					return nil, err
+				}
+			}
+
+			return nil, fmt.Errorf("invalid refresh token")
+		}
+		return nil, err
+	}
+
+	if refreshToken.ExpiresAt < time.Now().Unix() {
+		return nil, fmt.Errorf("refresh token has expired")
+	}
+
+	var user domain.User
+	err = conn.UserCollection.FindOne(context.TODO(), bson.M{"_id": refreshToken.UserId}).Decode(&user)
+
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, fmt.Errorf("user not found")
+		}
+		return nil, err
+	}
+
+	return a.issueTokens(conn, &user, refreshToken.SessionId)
+}
+
+func(a AuthRepoImpl) Logout(sessionId string) error {
+	conn := database.MongoConnectionPool.Get().(*database.Connection)
+	defer database.MongoConnectionPool.Put(conn)
+
+	filter := bson.M{"sessionId": sessionId}
+	update := bson.M{"$set": bson.M{"isRevoked": true}}
+
+	_, err := conn.RefreshTokenCollection.UpdateMany(context.TODO(), filter, update)
+
+	if err != nil {
+		return err
+	}
+
+	_, err = conn.SessionCollection.UpdateOne(context.TODO(), filter, update)
+
+	if err != nil {
+		return err
+	}
+
+	expiration, err := strconv.Atoi(config.Config("EXPIRATION"))
+
+	if err != nil {
+		return err
+	}
+
+	// access tokens already issued for the session stay signed, so they are rejected until they expire
+	return cache.RevokeSession(sessionId, time.Duration(expiration) * time.Minute)
+}
+
+func(a AuthRepoImpl) FindAllSessions(userId primitive.ObjectID, currentSessionId string) (*[]domain.Session, error) {
+	conn := database.MongoConnectionPool.Get().(*database.Connection)
+	defer database.MongoConnectionPool.Put(conn)
+
+	findOptions := options.Find().SetSort(bson.M{"lastSeenAt": -1})
+
+	cur, err := conn.SessionCollection.Find(context.TODO(), bson.M{
+		"userId": userId,
+		"isRevoked": false,
+		"expiresAt": bson.M{"$gt": time.Now().Unix()},
+	}, findOptions)
+
+	if err != nil {
+		return nil, fmt.Errorf("error processing data")
+	}
+
+	sessions := make([]domain.Session, 0)
+	if err = cur.All(context.TODO(), &sessions); err != nil {
+		return nil, fmt.Errorf("error processing data")
+	}
+
+	for i := range sessions {
+		sessions[i].IsCurrent = sessions[i].SessionId == currentSessionId
+	}
+
+	return &sessions, nil
+}
+
+func(a AuthRepoImpl) RevokeSession(userId primitive.ObjectID, id primitive.ObjectID) error {
+	conn := database.MongoConnectionPool.Get().(*database.Connection)
+	defer database.MongoConnectionPool.Put(conn)
+
+	var session domain.Session
+	err := conn.SessionCollection.FindOne(context.TODO(), bson.M{"_id": id, "userId": userId, "isRevoked": false}).Decode(&session)
+
+	if err != nil {
+		return err
+	}
+
+	return a.Logout(session.SessionId)
+}
+
+func(a AuthRepoImpl) RevokeOtherSessions(userId primitive.ObjectID, currentSessionId string) error {
+	conn := database.MongoConnectionPool.Get().(*database.Connection)
+	defer database.MongoConnectionPool.Put(conn)
+
+	cur, err := conn.SessionCollection.Find(context.TODO(), bson.M{
+		"userId": userId,
+		"isRevoked": false,
+		"sessionId": bson.M{"$ne": currentSessionId},
+	})
+
+	if err != nil {
+		return fmt.Errorf("error processing data")
+	}
+
+	var sessions []domain.Session
+	if err = cur.All(context.TODO(), &sessions); err != nil {
+		return fmt.Errorf("error processing data")
+	}
+
+	for _, session := range sessions {
+		err = a.Logout(session.SessionId)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// RevokeAllSessions logs the user out everywhere, impersonations of the user included, for when the tokens
+// they hold no longer match the account
+func(a AuthRepoImpl) RevokeAllSessions(userId primitive.ObjectID) error {
+	err := a.RevokeOtherSessions(userId, "")
+
+	if err != nil {
+		return err
+	}
+
+	conn := database.MongoConnectionPool.Get().(*database.Connection)
+	defer database.MongoConnectionPool.Put(conn)
+
+	// impersonation tokens have no session document, the ones started recently may still be alive
+	since := time.Now().Add(-domain.ImpersonationExpiration())
+
+	sessionIds, err := conn.ImpersonationAuditCollection.Distinct(context.TODO(), "sessionId", bson.M{
+		"userId":    userId,
+		"action":    domain.ImpersonationStarted,
+		"createdAt": bson.M{"$gt": since},
+	})
+
+	if err != nil {
+		return fmt.Errorf("error processing data")
+	}
+
+	for _, sessionId := range sessionIds {
+		if id, ok := sessionId.(string); ok {
+			err = cache.RevokeSession(id, domain.ImpersonationExpiration())
+
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func(a AuthRepoImpl) FindAllDevices(userId primitive.ObjectID) (*[]domain.Device, error) {
+	conn := database.MongoConnectionPool.Get().(*database.Connection)
+	defer database.MongoConnectionPool.Put(conn)
+
+	findOptions := options.Find().SetSort(bson.M{"lastSeenAt": -1})
+
+	cur, err := conn.DeviceCollection.Find(context.TODO(), bson.M{"userId": userId}, findOptions)
+
+	if err != nil {
+		return nil, fmt.Errorf("error processing data")
+	}
+
+	devices := make([]domain.Device, 0)
+	if err = cur.All(context.TODO(), &devices); err != nil {
+		return nil, fmt.Errorf("error processing data")
+	}
+
+	return &devices, nil
+}
+
+func(a AuthRepoImpl) ConfirmDevice(userId primitive.ObjectID, id primitive.ObjectID) error {
+	conn := database.MongoConnectionPool.Get().(*database.Connection)
+	defer database.MongoConnectionPool.Put(conn)
+
+	res, err := conn.DeviceCollection.UpdateOne(context.TODO(), bson.M{"_id": id, "userId": userId},
+		bson.M{"$set": bson.M{"status": domain.DeviceStatusConfirmed}})
+
+	if err != nil {
+		return fmt.Errorf("error processing data")
+	}
+
+	if res.MatchedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+
+	return nil
+}
+
+// DenyDevice signs the device out and refuses its logins until it's confirmed,
+// the device of the current session can't be denied
+func(a AuthRepoImpl) DenyDevice(userId primitive.ObjectID, id primitive.ObjectID, currentSessionId string) error {
+	conn := database.MongoConnectionPool.Get().(*database.Connection)
+	defer database.MongoConnectionPool.Put(conn)
+
+	var current domain.Session
+	err := conn.SessionCollection.FindOne(context.TODO(), bson.M{"sessionId": currentSessionId}).Decode(&current)
+
+	if err == nil && current.DeviceId == id {
+		return fmt.Errorf("the device you are using can't be denied")
+	}
+
+	var device domain.Device
+	err = conn.DeviceCollection.FindOneAndUpdate(context.TODO(), bson.M{"_id": id, "userId": userId},
+		bson.M{"$set": bson.M{"status": domain.DeviceStatusDenied}}).Decode(&device)
+
+	if err != nil {
+		return err
+	}
+
+	cur, err := conn.SessionCollection.Find(context.TODO(), bson.M{"userId": userId, "deviceId": id, "isRevoked": false})
+
+	if err != nil {
+		return fmt.Errorf("error processing data")
+	}
+
+	var sessions []domain.Session
+	if err = cur.All(context.TODO(), &sessions); err != nil {
+		return fmt.Errorf("error processing data")
+	}
+
+	for _, session := range sessions {
+		err = a.Logout(session.SessionId)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	var user domain.User
+	err = conn.UserCollection.FindOne(context.TODO(), bson.M{"_id": userId}).Decode(&user)
+
+	if err == nil {
+		sendAuthEvent("device_denied", &user, user.Username + " denied the device IP: " + device.Ip + "; User agent: " + device.UserAgent)
+	}
+
+	return nil
+}
+
+// FindSecurityActivity newest first, the cursor is the last activity of the previous page
+func(a AuthRepoImpl) FindSecurityActivity(userId primitive.ObjectID, cursor string, limit int64) (*domain.Page, error) {
+	conn := database.MongoConnectionPool.Get().(*database.Connection)
+	defer database.MongoConnectionPool.Put(conn)
+
+	c, err := domain.DecodeCursor(cursor)
+
+	if err != nil {
+		return nil, err
+	}
+
+	filter := bson.M{"userId": userId}
+
+	if !c.Id.IsZero() {
+		filter["_id"] = bson.M{"$lt": c.Id}
+	}
+
+	// one extra document tells whether there is another page
+	findOptions := options.Find().SetSort(bson.M{"_id": -1}).SetLimit(limit + 1)
+
+	cur, err := conn.SecurityActivityCollection.Find(context.TODO(), filter, findOptions)
+
+	if err != nil {
+		return nil, fmt.Errorf("error processing data")
+	}
+
+	activities := make([]domain.SecurityActivity, 0)
+	if err = cur.All(context.TODO(), &activities); err != nil {
+		return nil, fmt.Errorf("error processing data")
+	}
+
+	page := &domain.Page{}
+
+	if int64(len(activities)) > limit {
+		activities = activities[:limit]
+		page.HasMore = true
+		page.NextCursor = domain.Cursor{Id: activities[limit-1].Id}.Encode()
+	}
+
+	page.Items = activities
+
+	return page, nil
+}
+
+func(a AuthRepoImpl) EnrollMfa(userId primitive.ObjectID) (*domain.MfaEnrollment, error) {
+	conn := database.MongoConnectionPool.Get().(*database.Connection)
+	defer database.MongoConnectionPool.Put(conn)
+
+	var user domain.User
+	err := conn.UserCollection.FindOne(context.TODO(), bson.M{"_id": userId}).Decode(&user)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if user.MfaEnabled {
+		return nil, fmt.Errorf("mfa is already enabled")
+	}
+
+	secret, err := authHelper.GenerateTOTPSecret()
+
+	if err != nil {
+		return nil, err
+	}
+
+	// the secret is not used for logins until it has been confirmed with a code
+	update := bson.M{"$set": bson.M{"mfaSecret": secret, "mfaEnabled": false, "updatedAt": time.Now()}}
+
+	_, err = conn.UserCollection.UpdateOne(context.TODO(), bson.M{"_id": userId}, update)
+
+	if err != nil {
+		return nil, fmt.Errorf("error processing data")
+	}
+
+	issuer := config.Config("MFA_ISSUER")
+
+	if issuer == "" {
+		issuer = "user-service"
+	}
+
+	return &domain.MfaEnrollment{Secret: secret, Uri: authHelper.TOTPURI(issuer, user.Email, secret)}, nil
+}
+
+func(a AuthRepoImpl) ConfirmMfa(userId primitive.ObjectID, code string) ([]string, error) {
+	var login domain.Authentication
+
+	conn := database.MongoConnectionPool.Get().(*database.Connection)
+	defer database.MongoConnectionPool.Put(conn)
+
+	var user domain.User
+	err := conn.UserCollection.FindOne(context.TODO(), bson.M{"_id": userId}).Decode(&user)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if user.MfaEnabled {
+		return nil, fmt.Errorf("mfa is already enabled")
+	}
+
+	if user.MfaSecret == "" {
+		return nil, fmt.Errorf("mfa enrollment has not been started")
+	}
+
+	step, valid := authHelper.ValidateTOTP(user.MfaSecret, code, authHelper.Clock())
+
+	if !valid {
+		return nil, fmt.Errorf("invalid code")
+	}
+
+	codes, err := authHelper.GenerateRecoveryCodes(recoveryCodeCount)
+
+	if err != nil {
+		return nil, err
+	}
+
+	hashes := make([]string, 0, len(codes))
+
+	for _, c := range codes {
+		h, err := login.SignToken([]byte(c))
+
+		if err != nil {
+			return nil, err
+		}
+
+		hashes = append(hashes, string(h))
+	}
+
+	update := bson.M{"$set": bson.M{"mfaEnabled": true, "mfaLastUsedStep": step, "mfaRecoveryCodes": hashes, "updatedAt": time.Now()}}
+
+	_, err = conn.UserCollection.UpdateOne(context.TODO(), bson.M{"_id": userId}, update)
+
+	if err != nil {
+		return nil, fmt.Errorf("error processing data")
+	}
+
+	return codes, nil
+}
+
+func(a AuthRepoImpl) DisableMfa(userId primitive.ObjectID, code string) error {
+	conn := database.MongoConnectionPool.Get().(*database.Connection)
+	defer database.MongoConnectionPool.Put(conn)
+
+	var user domain.User
+	err := conn.UserCollection.FindOne(context.TODO(), bson.M{"_id": userId}).Decode(&user)
+
+	if err != nil {
+		return err
+	}
+
+	if !user.MfaEnabled {
+		return fmt.Errorf("mfa is not enabled")
+	}
+
+	err = a.checkMfaCode(conn, &user, code)
+
+	if err != nil {
+		return err
+	}
+
+	update := bson.M{"$set": bson.M{"mfaEnabled": false, "mfaSecret": "", "mfaLastUsedStep": 0, "mfaRecoveryCodes": []string{}, "updatedAt": time.Now()}}
+
+	_, err = conn.UserCollection.UpdateOne(context.TODO(), bson.M{"_id": userId}, update)
+
+	if err != nil {
+		return fmt.Errorf("error processing data")
+	}
+
+	return nil
+}
+
+func(a AuthRepoImpl) VerifyMfa(mfaToken string, code string, ip string, ips []string, userAgent string) (*domain.UserDto, *domain.AuthTokens, error) {
+	var login domain.Authentication
+
+	conn := database.MongoConnectionPool.Get().(*database.Connection)
+	defer database.MongoConnectionPool.Put(conn)
+
+	pending, err := login.ParseMfaPendingJWT(mfaToken)
+
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid mfa token")
+	}
+
+	var user domain.User
+	err = conn.UserCollection.FindOne(context.TODO(), bson.M{"_id": pending.Id}).Decode(&user)
+
+	if err != nil {
+		return nil, nil, fmt.Errorf("user not found")
+	}
+
+	if !user.MfaEnabled {
+		return nil, nil, fmt.Errorf("mfa is not enabled")
+	}
+
+	if user.IsLocked && user.LockedUntil > time.Now().Unix() {
+		return nil, nil, domain.ErrAccountLocked
+	}
+
+	err = a.checkMfaCode(conn, &user, code)
+
+	if err != nil {
+		// wrong codes count like wrong passwords so the code can't be guessed
+		a.recordFailedLogin(conn, &user, ip)
+		return nil, nil, err
+	}
+
+	return a.startSession(conn, &user, ip, ips, userAgent, domain.LoginMethodMfa)
+}
+
+// checkMfaCode accepts a TOTP code or a recovery code, both can only be used once
+func(a AuthRepoImpl) checkMfaCode(conn *database.Connection, user *domain.User, code string) error {
+	var login domain.Authentication
+
+	step, valid := authHelper.ValidateTOTP(user.MfaSecret, code, authHelper.Clock())
+
+	if valid {
+		filter := bson.M{"_id": user.Id, "mfaLastUsedStep": bson.M{"$lt": step}}
+		update := bson.M{"$set": bson.M{"mfaLastUsedStep": step}}
+
+		res, err := conn.UserCollection.UpdateOne(context.TODO(), filter, update)
+
+		if err != nil {
+			return fmt.Errorf("error processing data")
+		}
+
+		if res.ModifiedCount == 0 {
+			return fmt.Errorf("code has already been used")
+		}
+
+		return nil
+	}
+
+	h, err := login.SignToken([]byte(authHelper.NormalizeRecoveryCode(code)))
+
+	if err != nil {
+		return err
+	}
+
+	filter := bson.M{"_id": user.Id, "mfaRecoveryCodes": string(h)}
+	update := bson.M{"$pull": bson.M{"mfaRecoveryCodes": string(h)}}
+
+	res, err := conn.UserCollection.UpdateOne(context.TODO(), filter, update)
+
+	if err != nil {
+		return fmt.Errorf("error processing data")
+	}
+
+	if res.ModifiedCount == 0 {
+		return fmt.Errorf("invalid code")
+	}
+
+	return nil
+}
+
+// Impersonate issues an admin a short lived token acting as the user, admins can't impersonate each other
+func(a AuthRepoImpl) Impersonate(actor *domain.Authentication, username string, ip string) (*domain.UserDto, *domain.AuthTokens, error) {
+	conn := database.MongoConnectionPool.Get().(*database.Connection)
+	defer database.MongoConnectionPool.Put(conn)
+
+	var user domain.User
+	err := conn.UserCollection.FindOne(context.TODO(), bson.M{"username": username}).Decode(&user)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if user.Id == actor.Id {
+		return nil, nil, fmt.Errorf("you can't impersonate yourself")
+	}
+
+	for _, p := range domain.EffectivePermissions(user.Role, user.Permissions) {
+		if p == domain.PermissionImpersonate {
+			return nil, nil, fmt.Errorf("users who can impersonate can't be impersonated")
+		}
+	}
+
+	// the token gets a session of its own so ending the impersonation doesn't sign the admin out
+	sessionId := utils.UUIDv4()
+
+	var login domain.Authentication
+	accessToken, err := login.GenerateImpersonationJWT(user, &domain.Actor{Id: actor.Id, Username: actor.Username}, sessionId)
+
+	if err != nil {
+		return nil, nil, fmt.Errorf("error generating token")
+	}
+
+	audit := &domain.ImpersonationAudit{
+		Action:        domain.ImpersonationStarted,
+		ActorId:       actor.Id,
+		ActorUsername: actor.Username,
+		UserId:        user.Id,
+		Username:      user.Username,
+		SessionId:     sessionId,
+		Ip:            ip,
+	}
+
+	// an impersonation that can't be audited doesn't start
+	err = a.RecordImpersonation(audit)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	sendImpersonationEvent(audit)
+	recordActivity(&domain.SecurityActivity{UserId: user.Id, Action: domain.ActivityImpersonated})
+
+	tokens := &domain.AuthTokens{AccessToken: accessToken, ExpiresIn: int64(domain.ImpersonationExpiration().Seconds())}
+
+	return domain.UserMapper(&user), tokens, nil
+}
+
+// EndImpersonation revokes the impersonation token before it expires
+func(a AuthRepoImpl) EndImpersonation(u *domain.Authentication, ip string) error {
+	if !u.IsImpersonated() {
+		return fmt.Errorf("not impersonating a user")
+	}
+
+	err := cache.RevokeSession(u.SessionId, domain.ImpersonationExpiration())
+
+	if err != nil {
+		return err
+	}
+
+	audit := &domain.ImpersonationAudit{
+		Action:        domain.ImpersonationEnded,
+		ActorId:       u.Actor.Id,
+		ActorUsername: u.Actor.Username,
+		UserId:        u.Id,
+		Username:      u.Username,
+		SessionId:     u.SessionId,
+		Ip:            ip,
+	}
+
+	err = a.RecordImpersonation(audit)
+
+	if err != nil {
+		return err
+	}
+
+	sendImpersonationEvent(audit)
+
+	return nil
+}
+
+// RecordImpersonation writes an entry of the impersonation audit log
+func(a AuthRepoImpl) RecordImpersonation(audit *domain.ImpersonationAudit) error {
+	conn := database.MongoConnectionPool.Get().(*database.Connection)
+	defer database.MongoConnectionPool.Put(conn)
+
+	audit.Id = primitive.NewObjectID()
+	audit.CreatedAt = time.Now()
+
+	_, err := conn.ImpersonationAuditCollection.InsertOne(context.TODO(), audit)
+
+	if err != nil {
+		return fmt.Errorf("error recording impersonation")
+	}
+
+	return nil
+}
+
+func(a AuthRepoImpl) UnlockAccount(username string) error {
+	conn := database.MongoConnectionPool.Get().(*database.Connection)
+	defer database.MongoConnectionPool.Put(conn)
+
+	var user domain.User
+	err := conn.UserCollection.FindOne(context.TODO(), bson.M{"username": username}).Decode(&user)
+
+	if err != nil {
+		return err
+	}
+
+	return a.clearLock(conn, user.Id)
+}
+
+// recordFailedLogin counts the failure against the account and the IP, the account is locked once it has too many
+func(a AuthRepoImpl) recordFailedLogin(conn *database.Connection, user *domain.User, ip string) {
+	_, _ = cache.RecordFailedLogin(ipLoginKey(ip), loginAttemptWindow())
+
+	count, err := cache.RecordFailedLogin(user.Id.Hex(), loginAttemptWindow())
+
+	sendAuthEvent("login_failed", user, user.Username + " failed to log in IP: " + ip)
+	recordActivity(&domain.SecurityActivity{UserId: user.Id, Action: domain.ActivityLoginFailed, Ip: ip})
+
+	if err != nil || count < int64(config.ConfigInt("LOGIN_MAX_ATTEMPTS", 5)) {
+		return
+	}
+
+	// every lock in a row lasts twice as long as the one before it
+	lockout := time.Duration(config.ConfigInt("LOCKOUT_DURATION", 5)) * time.Minute
+	maxLockout := time.Duration(config.ConfigInt("LOCKOUT_MAX_DURATION", 1440)) * time.Minute
+
+	for i := 0; i < user.LockCount && lockout < maxLockout; i++ {
+		lockout *= 2
+	}
+
+	if lockout > maxLockout {
+		lockout = maxLockout
+	}
+
+	lockedUntil := time.Now().Add(lockout)
+
+	filter := bson.M{"_id": user.Id}
+	update := bson.M{"$set": bson.M{"isLocked": true, "lockedUntil": lockedUntil.Unix()}, "$inc": bson.M{"lockCount": 1}}
+
+	_, err = conn.UserCollection.UpdateOne(context.TODO(), filter, update)
+
+	if err != nil {
+		fmt.Println("Failed to lock account...")
+		return
+	}
+
+	_ = cache.ResetFailedLogins(user.Id.Hex())
+
+	sendAuthEvent("account_locked", user, user.Username + " has been locked until " + lockedUntil.Format(time.RFC3339))
+	recordActivity(&domain.SecurityActivity{UserId: user.Id, Action: domain.ActivityAccountLocked, Ip: ip})
+
+	email.Send(email.SecurityAlertEmail(user.Email, user.Username, "Your account has been locked",
+		"Your account has been locked until " + lockedUntil.Format(time.RFC1123) + " after too many failed login attempts."))
+}
+
+func(a AuthRepoImpl) clearLock(conn *database.Connection, id primitive.ObjectID) error {
+	filter := bson.M{"_id": id}
+	update := bson.M{"$set": bson.M{"isLocked": false, "lockedUntil": 0, "lockCount": 0}}
+
+	res, err := conn.UserCollection.UpdateOne(context.TODO(), filter, update)
+
+	if err != nil {
+		return fmt.Errorf("error processing data")
+	}
+
+	if res.MatchedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+
+	return cache.ResetFailedLogins(id.Hex())
+}
+
+// startSession records a new session for a user that passed every login step and issues its tokens
+// startSession method is how the user authenticated and is recorded on the login event
+func(a AuthRepoImpl) startSession(conn *database.Connection, user *domain.User, ip string, ips []string, userAgent string, method string) (*domain.UserDto, *domain.AuthTokens, error) {
+	device, err := a.recordDevice(conn, user, ip, userAgent, method)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if device.Status == domain.DeviceStatusDenied {
+		return nil, nil, domain.ErrDeviceDenied
+	}
+
+	session := new(domain.Session)
+	session.Id = primitive.NewObjectID()
+	session.UserId = user.Id
+	session.SessionId = utils.UUIDv4()
+	session.DeviceId = device.Id
+	session.UserAgent = userAgent
+	session.Ip = ip
+	session.CreatedAt = time.Now()
+	session.LastSeenAt = time.Now()
+
+	_, err = conn.SessionCollection.InsertOne(context.TODO(), session)
+
+	if err != nil {
+		return nil, nil, fmt.Errorf("error processing data")
+	}
+
+	tokens, err := a.issueTokens(conn, user, session.SessionId)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	userDto := domain.UserMapper(user)
+
+	go func() {
+		filter := bson.D{{"_id", user.Id}}
+		update := bson.D{{"$set", bson.D{{"lastLoginIp", ip}, {"lastLoginIps", ips}}}}
+
+		_, err := conn.UserCollection.UpdateOne(context.TODO(),
+			filter, update)
+
+		if err != nil {
+			panic(err)
+		}
+		return
+	}()
+
+	recordActivity(&domain.SecurityActivity{UserId: user.Id, Action: domain.ActivityLogin, Method: method, Ip: ip, UserAgent: userAgent, Country: device.Country})
+
+	go func() {
+		event := new(domain.Event)
+		event.Action = "login"
+		event.Target = user.Username
+		event.ResourceId = user.Id
+		event.ActorUsername = user.Username
+		event.Message = user.Username + " has logged in with " + method + " IP: " + ip + "; IPs: " + strings.Join(ips, ", ")
+		event.Method = method
+		err := events.SendEventMessage(event, 0)
+		if err != nil {
+			fmt.Println("Error publishing...")
+			return
+		}
+	}()
+
+	return userDto, tokens, nil
+}
+
+// recordDevice finds the device of the login in the user's known devices, an unseen one is added as pending and
+// the user is alerted. The first device a user logs in from is confirmed, there is nothing to compare it with.
+func(a AuthRepoImpl) recordDevice(conn *database.Connection, user *domain.User, ip string, userAgent string, method string) (*domain.Device, error) {
+	fingerprint := domain.DeviceFingerprint(userAgent, ip)
+	filter := bson.M{"userId": user.Id, "fingerprint": fingerprint}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+
+	var device domain.Device
+	err := conn.DeviceCollection.FindOneAndUpdate(context.TODO(), filter, bson.M{"$set": bson.M{"lastSeenAt": time.Now()}}, opts).Decode(&device)
+
+	if err == nil {
+		return &device, nil
+	}
+
+	if err != mongo.ErrNoDocuments {
+		return nil, fmt.Errorf("error processing data")
+	}
+
+	known, err := conn.DeviceCollection.CountDocuments(context.TODO(), bson.M{"userId": user.Id})
+
+	if err != nil {
+		return nil, fmt.Errorf("error processing data")
+	}
+
+	device = domain.Device{
+		Id:          primitive.NewObjectID(),
+		UserId:      user.Id,
+		Fingerprint: fingerprint,
+		UserAgent:   userAgent,
+		Ip:          ip,
+		Country:     geoip.Country(ip),
+		Status:      domain.DeviceStatusPending,
+		FirstSeenAt: time.Now(),
+		LastSeenAt:  time.Now(),
+	}
+
+	if known == 0 {
+		device.Status = domain.DeviceStatusConfirmed
+	}
+
+	_, err = conn.DeviceCollection.InsertOne(context.TODO(), device)
+
+	if mongo.IsDuplicateKeyError(err) {
+		// another login from the same device recorded it first and sent the alert
+		err = conn.DeviceCollection.FindOne(context.TODO(), filter).Decode(&device)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("error processing data")
+	}
+
+	if known == 0 {
+		return &device, nil
+	}
+
+	// a country none of the other devices were in is worth pointing out, without a GeoIP database it's always empty
+	newLocation := false
+
+	if device.Country != "" {
+		count, err := conn.DeviceCollection.CountDocuments(context.TODO(), bson.M{"userId": user.Id, "country": device.Country})
+		newLocation = err == nil && count == 1
+	}
+
+	location := "IP: " + ip
+
+	if device.Country != "" {
+		location += " (" + device.Country + ")"
+	}
+
+	message := user.Username + " has logged in from a new device with " + method + " " + location + "; User agent: " + userAgent
+
+	if newLocation {
+		message = user.Username + " has logged in from a new device in a new country with " + method + " " + location + "; User agent: " + userAgent
+	}
+
+	sendAuthEvent("new_device_login", user, message)
+	recordActivity(&domain.SecurityActivity{UserId: user.Id, Action: domain.ActivityNewDeviceLogin, Method: method, Ip: ip, UserAgent: userAgent, Country: device.Country})
+
+	subject := "New sign-in to your account"
+
+	if newLocation {
+		subject = "New sign-in to your account from " + device.Country
+	}
+
+	email.Send(email.SecurityAlertEmail(user.Email, user.Username, subject,
+		"Your account was just signed in to from a new device: " + userAgent + ", " + location + ". " +
+		"If this was you, confirm the device in your account's devices. If it wasn't, deny it there to sign it out and change your password."))
+
+	return &device, nil
+}
+
+// issueTokens generates an access token and a new refresh token for the session
+func(a AuthRepoImpl) issueTokens(conn *database.Connection, user *domain.User, sessionId string) (*domain.AuthTokens, error) {
+	var login domain.Authentication
+
+	accessToken, err := login.GenerateJWT(*user, sessionId)
+
+	if err != nil {
+		return nil, fmt.Errorf("error generating token")
+	}
+
+	expiration := config.ConfigInt("REFRESH_TOKEN_EXPIRATION", 10080)
+
+	token, err := generateSignedToken()
+
+	if err != nil {
+		return nil, err
+	}
+
+	tokenHash, err := login.SignToken([]byte(token))
+
+	if err != nil {
+		return nil, err
+	}
+
+	refreshToken := new(domain.RefreshToken)
+	refreshToken.Id = primitive.NewObjectID()
+	refreshToken.UserId = user.Id
+	refreshToken.SessionId = sessionId
+	refreshToken.TokenHash = string(tokenHash)
+	refreshToken.ExpiresAt = time.Now().Add(time.Duration(expiration) * time.Minute).Unix()
+	refreshToken.CreatedAt = time.Now()
+
+	_, err = conn.RefreshTokenCollection.InsertOne(context.TODO(), refreshToken)
+
+	if err != nil {
+		return nil, fmt.Errorf("error processing data")
+	}
+
+	filter := bson.M{"sessionId": sessionId}
+	update := bson.M{"$set": bson.M{"lastSeenAt": time.Now(), "expiresAt": refreshToken.ExpiresAt}}
+
+	_, err = conn.SessionCollection.UpdateOne(context.TODO(), filter, update)
+
+	if err != nil {
+		return nil, fmt.Errorf("error processing data")
+	}
+
+	expiresIn := int64(config.ConfigInt("EXPIRATION", 0)) * 60
+
+	return &domain.AuthTokens{AccessToken: accessToken, RefreshToken: token, ExpiresIn: expiresIn}, nil
+}
+
+func(a AuthRepoImpl) ResetPasswordQuery(address string) error {
+	conn := database.MongoConnectionPool.Get().(*database.Connection)
+	defer database.MongoConnectionPool.Put(conn)
+
+	var user domain.User
+	err := conn.UserCollection.FindOne(context.TODO(), bson.D{{"email", strings.ToLower(address)}}).Decode(&user)
+
+	if err != nil {
+		// ErrNoDocuments means that the filter did not match any documents in the collection
+		if err == mongo.ErrNoDocuments {
+			return fmt.Errorf("email %v was not found", address)
+		}
+		return err
+	}
+
+	// logic to send email with JWT
+	if user.TokenHash == "" || user.TokenExpiresAt < time.Now().Unix() {
+		a := new(domain.Authentication)
+		h := utils.UUIDv4()
+		s, err := a.SignToken([]byte(h))
+
+		if err != nil {
+			return err
+		}
+
+		expiration, err := strconv.Atoi(config.Config("PASSWORD_RESET_TOKEN_EXPIRATION"))
+
+		if err != nil {
+			return err
+		}
+
+		hash := h + "-" + string(s)
+		user.TokenHash = hash
+		user.TokenExpiresAt = time.Now().Add(time.Duration(expiration) * time.Minute).Unix()
+		ur := new(UserRepoImpl)
+		_, err = ur.UpdateByID(user.Id, &user)
+
+		// a link whose token wasn't saved would never work
+		if err != nil {
+			return err
+		}
+	}
+
+	email.Send(email.PasswordResetEmail(user.Email, user.Username, user.TokenHash))
+
+	return nil
+}
+
+func(a AuthRepoImpl) ResetPassword(token, password string) error {
+	conn := database.MongoConnectionPool.Get().(*database.Connection)
+	defer database.MongoConnectionPool.Put(conn)
+
+	user := new(domain.User)
+	ur := new(UserRepoImpl)
+	err := conn.UserCollection.FindOne(context.TODO(), bson.D{{"tokenHash", token}}).Decode(&user)
+	if err != nil {
+		// ErrNoDocuments means that the filter did not match any documents in the collection
+		if err == mongo.ErrNoDocuments {
+			return fmt.Errorf("no token found")
+		}
+		return err
+	}
+
+	if user.TokenExpiresAt < time.Now().Unix() {
+		return fmt.Errorf("token has expired")
+	}
+
+	err = domain.ValidatePassword(password, user.Username, user.Email, previousPasswords(user))
+
+	if err != nil {
+		return err
+	}
+
+	hashedPassword, err := domain.HashPassword(password)
+
+	if err != nil {
+		return err
+	}
+
+	// update password logic
+	_, err = ur.UpdatePassword(user.Id, hashedPassword)
+
+	if err != nil {
+		return err
+	}
+
+	recordActivity(&domain.SecurityActivity{UserId: user.Id, Action: domain.ActivityPasswordReset})
+
+	return nil
+}
+
+func (a AuthRepoImpl) VerifyCode(code string) error{
+	conn := database.MongoConnectionPool.Get().(*database.Connection)
+	defer database.MongoConnectionPool.Put(conn)
+
+	var user domain.User
+	err := conn.UserCollection.FindOne(context.TODO(), bson.D{{"verificationCode", code}}).Decode(&user)
+
+	if err != nil {
+		// ErrNoDocuments means that the filter did not match any documents in the collection
+		if err == mongo.ErrNoDocuments {
+			return fmt.Errorf("no token found")
+		}
+		return err
+	}
+
+	// codes sent before they had an expiry have none
+	if user.VerificationCodeExpiresAt != 0 && user.VerificationCodeExpiresAt < time.Now().Unix() {
+		return fmt.Errorf("code has expired")
+	}
+
+	// the code was sent to a new address, confirming it switches the email
+	if user.PendingEmail != "" {
+		return a.confirmEmailChange(conn, &user)
+	}
+
+	if user.IsVerified {
+		return fmt.Errorf("user email already verified")
+	}
+
+	// filtering on the code makes it single use when the same link is opened twice at once
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	filter := bson.M{"_id": user.Id, "verificationCode": code}
+	update := bson.M{"$set": bson.M{"isVerified": true, "verificationCode": "", "verificationCodeExpiresAt": 0, "updatedAt": time.Now()}}
+
+	err = conn.UserCollection.FindOneAndUpdate(context.TODO(), filter, update, opts).Decode(&user)
+
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return fmt.Errorf("no token found")
+		}
+		return err
+	}
+
+	recordActivity(&domain.SecurityActivity{UserId: user.Id, Action: domain.ActivityAccountVerified})
+
+	go func() {
+		err := events.SendKafkaMessage(&user, 200)
+		if err != nil {
+			fmt.Println("Error publishing...")
+			return
+		}
+	}()
+
+	return nil
+}
+
+// ResendVerification sends a new code to an unverified address, unknown and verified addresses get no email
+// and no error so the endpoint can't be used to find out who has an account
+func(a AuthRepoImpl) ResendVerification(address string) error {
+	window := time.Duration(config.ConfigInt("VERIFICATION_RESEND_WINDOW", 60)) * time.Minute
+	count, err := cache.RecordVerificationResend(address, window)
+
+	if err == nil && count > int64(config.ConfigInt("VERIFICATION_RESEND_MAX", 3)) {
+		return domain.ErrTooManyRequests
+	}
+
+	conn := database.MongoConnectionPool.Get().(*database.Connection)
+	defer database.MongoConnectionPool.Put(conn)
+
+	var user domain.User
+	err = conn.UserCollection.FindOne(context.TODO(), bson.M{"email": address, "isVerified": false}).Decode(&user)
+
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil
+		}
+		return err
+	}
+
+	code, err := a.renewVerificationCode(conn, &user)
+
+	if err != nil {
+		return err
+	}
+
+	email.Send(email.VerificationEmail(user.Email, user.Username, code))
+
+	return nil
+}
+
+// renewVerificationCode replaces the code of an unverified account, the old code stops working
+func(a AuthRepoImpl) renewVerificationCode(conn *database.Connection, user *domain.User) (string, error) {
+	code, err := generateSignedToken()
+
+	if err != nil {
+		return "", err
+	}
+
+	// a pending email change would otherwise be confirmed by the new code
+	filter := bson.M{"_id": user.Id, "isVerified": false}
+	update := bson.M{"$set": bson.M{"verificationCode": code, "verificationCodeExpiresAt": domain.VerificationCodeExpiresAt(), "pendingEmail": "", "updatedAt": time.Now()}}
+
+	res, err := conn.UserCollection.UpdateOne(context.TODO(), filter, update)
+
+	if err != nil {
+		return "", fmt.Errorf("error processing data")
+	}
+
+	if res.MatchedCount == 0 {
+		return "", fmt.Errorf("user email already verified")
+	}
+
+	return code, nil
+}
+
+// RemindUnverifiedUsers emails a new code to accounts that are still unverified VERIFICATION_REMINDER_AFTER
+// minutes after signing up, they are purged VERIFICATION_GRACE_PERIOD minutes after the reminder
+func(a AuthRepoImpl) RemindUnverifiedUsers() error {
+	conn := database.MongoConnectionPool.Get().(*database.Connection)
+	defer database.MongoConnectionPool.Put(conn)
+
+	remindAfter := time.Duration(config.ConfigInt("VERIFICATION_REMINDER_AFTER", 1440)) * time.Minute
+	gracePeriod := time.Duration(config.ConfigInt("VERIFICATION_GRACE_PERIOD", 10080)) * time.Minute
+
+	// the ObjectId holds the creation time, users created before createdAt was set have one too
+	filter := bson.M{
+		"_id":                    bson.M{"$lt": primitive.NewObjectIDFromTimestamp(time.Now().Add(-remindAfter))},
+		"isVerified":             false,
+		"verificationRemindedAt": bson.M{"$in": bson.A{0, nil}},
+	}
+
+	cur, err := conn.UserCollection.Find(context.TODO(), filter)
+
+	if err != nil {
+		return err
+	}
+
+	var users []domain.User
+	err = cur.All(context.TODO(), &users)
+
+	if err != nil {
+		return err
+	}
+
+	for _, user := range users {
+		code, err := a.renewVerificationCode(conn, &user)
+
+		if err != nil {
+			continue
+		}
+
+		remindedAt := time.Now()
+
+		_, err = conn.UserCollection.UpdateOne(context.TODO(), bson.M{"_id": user.Id}, bson.M{"$set": bson.M{"verificationRemindedAt": remindedAt.Unix()}})
+
+		if err != nil {
+			fmt.Println("Error saving verification reminder...")
+			continue
+		}
+
+		email.Send(email.VerificationReminderEmail(user.Email, user.Username, code, remindedAt.Add(gracePeriod)))
+	}
+
+	return nil
+}
+
+// deleteUserData removes everything kept for a deleted user, their tokens are revoked first
+func(a AuthRepoImpl) deleteUserData(conn *database.Connection, userId primitive.ObjectID) error {
+	err := a.RevokeAllSessions(userId)
+
+	if err != nil {
+		return err
+	}
+
+	filter := bson.M{"userId": userId}
+
+	for _, collection := range []*mongo.Collection{conn.SessionCollection, conn.RefreshTokenCollection, conn.IdentityCollection,
+		conn.DeviceCollection, conn.ApiKeyCollection, conn.ConsentCollection, conn.SecurityActivityCollection} {
+		_, err = collection.DeleteMany(context.TODO(), filter)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// PurgeUnverifiedUsers deletes accounts that were reminded and still haven't verified once the grace period is over
+func(a AuthRepoImpl) PurgeUnverifiedUsers() error {
+	conn := database.MongoConnectionPool.Get().(*database.Connection)
+	defer database.MongoConnectionPool.Put(conn)
+
+	gracePeriod := time.Duration(config.ConfigInt("VERIFICATION_GRACE_PERIOD", 10080)) * time.Minute
+
+	filter := bson.M{
+		"isVerified":             false,
+		"verificationRemindedAt": bson.M{"$gt": 0, "$lt": time.Now().Add(-gracePeriod).Unix()},
+	}
+
+	cur, err := conn.UserCollection.Find(context.TODO(), filter)
+
+	if err != nil {
+		return err
+	}
+
+	var users []domain.User
+	err = cur.All(context.TODO(), &users)
+
+	if err != nil {
+		return err
+	}
+
+	for i := range users {
+		user := users[i]
+
+		// isVerified is checked again in case the user verified since the find
+		res, err := conn.UserCollection.DeleteOne(context.TODO(), bson.M{"_id": user.Id, "isVerified": false})
+
+		if err != nil || res.DeletedCount == 0 {
+			continue
+		}
+
+		err = a.deleteUserData(conn, user.Id)
+
+		if err != nil {
+			fmt.Println("Error deleting the data of a purged user...", err)
+		}
+
+		go func() {
+			err := events.SendKafkaMessage(&user, 204)
+			if err != nil {
+				fmt.Println("Error publishing...")
+				return
+			}
+		}()
+
+		go func() {
+			rdb := cache.RedisCachePool.Get().(*cache2.Cache)
+			defer cache.RedisCachePool.Put(rdb)
+
+			_ = rdb.Delete(context.TODO(), util.GenerateKey(user.Username, "finduserbyusername"))
+		}()
+	}
+
+	return nil
+}
+
+func(a AuthRepoImpl) ChangePassword(id primitive.ObjectID, currentPassword string, password string, currentSessionId string) error {
+	conn := database.MongoConnectionPool.Get().(*database.Connection)
+	defer database.MongoConnectionPool.Put(conn)
+
+	var user domain.User
+	err := conn.UserCollection.FindOne(context.TODO(), bson.M{"_id": id}).Decode(&user)
+
+	if err != nil {
+		return err
+	}
+
+	err = domain.CheckPassword(user.Password, currentPassword)
+
+	if err != nil {
+		return domain.ErrWrongPassword
+	}
+
+	err = domain.ValidatePassword(password, user.Username, user.Email, previousPasswords(&user))
+
+	if err != nil {
+		return err
+	}
+
+	hashedPassword, err := domain.HashPassword(password)
+
+	if err != nil {
+		return err
+	}
+
+	ur := new(UserRepoImpl)
+	updated, err := ur.UpdatePassword(id, hashedPassword)
+
+	if err != nil {
+		return err
+	}
+
+	// anyone else signed in with the old password loses access
+	err = a.RevokeOtherSessions(id, currentSessionId)
+
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		err := events.SendKafkaMessage(updated, 200)
+		if err != nil {
+			fmt.Println("Error publishing...")
+			return
+		}
+	}()
+
+	recordActivity(&domain.SecurityActivity{UserId: user.Id, Action: domain.ActivityPasswordChanged})
+
+	email.Send(email.SecurityAlertEmail(user.Email, user.Username, "Your password has been changed",
+		"The password for your account was just changed and your other sessions have been signed out."))
+
+	return nil
+}
+
+func(a AuthRepoImpl) ChangeEmail(id primitive.ObjectID, address string, password string) error {
+	conn := database.MongoConnectionPool.Get().(*database.Connection)
+	defer database.MongoConnectionPool.Put(conn)
+
+	var user domain.User
+	err := conn.UserCollection.FindOne(context.TODO(), bson.M{"_id": id}).Decode(&user)
+
+	if err != nil {
+		return err
+	}
+
+	err = domain.CheckPassword(user.Password, password)
+
+	if err != nil {
+		return domain.ErrWrongPassword
+	}
+
+	if user.Email == address {
+		return fmt.Errorf("this is already your email")
+	}
+
+	count, err := conn.UserCollection.CountDocuments(context.TODO(), bson.M{"email": address})
+
+	if err != nil {
+		return fmt.Errorf("error processing data")
+	}
+
+	if count > 0 {
+		return fmt.Errorf("email is taken")
+	}
+
+	code, err := generateSignedToken()
+
+	if err != nil {
+		return err
+	}
+
+	// the address only changes once the code sent to it has been used
+	update := bson.M{"$set": bson.M{"pendingEmail": address, "verificationCode": code, "verificationCodeExpiresAt": domain.VerificationCodeExpiresAt(), "updatedAt": time.Now()}}
+
+	_, err = conn.UserCollection.UpdateOne(context.TODO(), bson.M{"_id": id}, update)
+
+	if err != nil {
+		return fmt.Errorf("error processing data")
+	}
+
+	email.Send(email.VerificationEmail(address, user.Username, code))
+
+	recordActivity(&domain.SecurityActivity{UserId: user.Id, Action: domain.ActivityEmailChangeRequested})
+
+	return nil
+}
+
+func(a AuthRepoImpl) confirmEmailChange(conn *database.Connection, user *domain.User) error {
+	count, err := conn.UserCollection.CountDocuments(context.TODO(), bson.M{"email": user.PendingEmail})
+
+	if err != nil {
+		return fmt.Errorf("error processing data")
+	}
+
+	if count > 0 {
+		return fmt.Errorf("email is taken")
+	}
+
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	filter := bson.M{"_id": user.Id, "verificationCode": user.VerificationCode}
+	update := bson.M{"$set": bson.M{"email": user.PendingEmail, "pendingEmail": "", "verificationCode": "", "verificationCodeExpiresAt": 0, "isVerified": true, "updatedAt": time.Now()}}
+
+	err = conn.UserCollection.FindOneAndUpdate(context.TODO(), filter, update, opts).Decode(user)
+
+	if err != nil {
+		return err
+	}
+
+	recordActivity(&domain.SecurityActivity{UserId: user.Id, Action: domain.ActivityEmailChanged})
+
+	go func() {
+		err := events.SendKafkaMessage(user, 200)
+		if err != nil {
+			fmt.Println("Error publishing...")
+			return
+		}
+	}()
+
+	go func() {
+		rdb := cache.RedisCachePool.Get().(*cache2.Cache)
+		defer cache.RedisCachePool.Put(rdb)
+
+		err := rdb.Delete(context.TODO(), util.GenerateKey(user.Username, "finduserbyusername"))
+
+		if err != nil {
+			fmt.Println("Not in cache, change email")
+			return
+		}
+
+		fmt.Println("Removed from cache, change email")
+	}()
+
+	return nil
+}
+
+// previousPasswords the current hash and the ones before it, users from before the history was kept only have the current one
+func previousPasswords(user *domain.User) []string {
+	return append([]string{user.Password}, user.PasswordHistory...)
+}
+
+// generateSignedToken a UUID followed by its signature, the same format as the reset and verification codes
+func generateSignedToken() (string, error) {
+	a := new(domain.Authentication)
+	h := utils.UUIDv4()
+	s, err := a.SignToken([]byte(h))
+
+	if err != nil {
+		return "", err
+	}
+
+	return h + "-" + string(s), nil
+}
+
+func ipLoginKey(ip string) string {
+	return "ip:" + ip
+}
+
+func loginAttemptWindow() time.Duration {
+	return time.Duration(config.ConfigInt("LOGIN_ATTEMPT_WINDOW", 15)) * time.Minute
+}
+
+func sendAuthEvent(action string, user *domain.User, message string) {
+	go func() {
+		event := new(domain.Event)
+		event.Action = action
+		event.Target = user.Username
+		event.ResourceId = user.Id
+		event.ActorUsername = user.Username
+		event.Message = message
+		err := events.SendEventMessage(event, 0)
+		if err != nil {
+			fmt.Println("Error publishing...")
+			return
+		}
+	}()
+}
+
+func sendImpersonationEvent(audit *domain.ImpersonationAudit) {
+	go func() {
+		err := events.SendEventMessage(domain.ImpersonationEvent(audit), 0)
+		if err != nil {
+			fmt.Println("Error publishing...")
+			return
+		}
+	}()
+}
+
+// recordActivity keeps the activity for the user to review, it's written in the background like the events
+func recordActivity(activity *domain.SecurityActivity) {
+	activity.Id = primitive.NewObjectID()
+	activity.CreatedAt = time.Now()
+
+	go func() {
+		conn := database.MongoConnectionPool.Get().(*database.Connection)
+		defer database.MongoConnectionPool.Put(conn)
+
+		_, err := conn.SecurityActivityCollection.InsertOne(context.TODO(), activity)
+
+		if err != nil {
+			fmt.Println("Error recording security activity...")
+			return
+		}
+	}()
 }
 
 func NewAuthRepoImpl() AuthRepoImpl {
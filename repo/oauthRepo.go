@@ -0,0 +1,18 @@
+package repo
+
+import (
+	"example.com/app/domain"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type OAuthRepo interface {
+	CreateClient(client *domain.CreateOAuthClient) (*domain.OAuthClientCredentials, error)
+	FindAllClients() (*[]domain.OAuthClient, error)
+	DeleteClient(clientId string) error
+	CheckAuthorization(request *domain.AuthorizationRequest) (*domain.AuthorizationResult, error)
+	Authorize(userId primitive.ObjectID, request *domain.AuthorizationRequest) (*domain.AuthorizationResult, error)
+	Token(request *domain.TokenRequest) (*domain.OAuthTokenResponse, error)
+	UserInfo(accessToken string) (map[string]interface{}, error)
+	FindAllConsents(userId primitive.ObjectID) (*[]domain.Consent, error)
+	DeleteConsent(userId primitive.ObjectID, clientId string) error
+}
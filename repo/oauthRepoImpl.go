@@ -0,0 +1,483 @@
+package repo
+
+import (
+	"context"
+	"encoding/json"
+	"example.com/app/cache"
+	"example.com/app/config"
+	"example.com/app/database"
+	"example.com/app/domain"
+	authHelper "example.com/app/helpers"
+	"fmt"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"golang.org/x/crypto/bcrypt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+type OAuthRepoImpl struct {
+}
+
+func (o OAuthRepoImpl) CreateClient(c *domain.CreateOAuthClient) (*domain.OAuthClientCredentials, error) {
+	conn := database.MongoConnectionPool.Get().(*database.Connection)
+	defer database.MongoConnectionPool.Put(conn)
+
+	if c.Name == "" || len(c.RedirectUris) == 0 {
+		return nil, fmt.Errorf("name and redirectUris are required")
+	}
+
+	for _, redirectUri := range c.RedirectUris {
+		u, err := url.Parse(redirectUri)
+
+		// redirect uris are compared exactly, so they can't have a fragment (RFC 6749 section 3.1.2)
+		if err != nil || !u.IsAbs() || u.Fragment != "" {
+			return nil, fmt.Errorf("invalid redirect uri %v", redirectUri)
+		}
+	}
+
+	for _, scope := range c.Scopes {
+		if !domain.IsScope(scope) {
+			return nil, fmt.Errorf("unsupported scope %v", scope)
+		}
+	}
+
+	clientId, err := authHelper.RandomString()
+
+	if err != nil {
+		return nil, err
+	}
+
+	client := new(domain.OAuthClient)
+	client.Id = primitive.NewObjectID()
+	client.ClientId = clientId
+	client.Name = c.Name
+	client.RedirectUris = c.RedirectUris
+	client.Scopes = c.Scopes
+	client.IsPublic = c.IsPublic
+	client.CreatedAt = time.Now()
+
+	if client.Scopes == nil {
+		client.Scopes = []string{}
+	}
+
+	credentials := &domain.OAuthClientCredentials{ClientId: clientId}
+
+	if !client.IsPublic {
+		credentials.ClientSecret, err = authHelper.RandomString()
+
+		if err != nil {
+			return nil, err
+		}
+
+		hash, err := bcrypt.GenerateFromPassword([]byte(credentials.ClientSecret), bcrypt.DefaultCost)
+
+		if err != nil {
+			return nil, err
+		}
+
+		client.ClientSecretHash = string(hash)
+	}
+
+	_, err = conn.OAuthClientCollection.InsertOne(context.TODO(), client)
+
+	if err != nil {
+		return nil, fmt.Errorf("error processing data")
+	}
+
+	return credentials, nil
+}
+
+func (o OAuthRepoImpl) FindAllClients() (*[]domain.OAuthClient, error) {
+	conn := database.MongoConnectionPool.Get().(*database.Connection)
+	defer database.MongoConnectionPool.Put(conn)
+
+	cur, err := conn.OAuthClientCollection.Find(context.TODO(), bson.M{})
+
+	if err != nil {
+		return nil, err
+	}
+
+	clients := make([]domain.OAuthClient, 0)
+	err = cur.All(context.TODO(), &clients)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &clients, nil
+}
+
+// DeleteClient the client's consents go with it, tokens it already has stay valid until they expire
+func (o OAuthRepoImpl) DeleteClient(clientId string) error {
+	conn := database.MongoConnectionPool.Get().(*database.Connection)
+	defer database.MongoConnectionPool.Put(conn)
+
+	res, err := conn.OAuthClientCollection.DeleteOne(context.TODO(), bson.M{"clientId": clientId})
+
+	if err != nil {
+		return fmt.Errorf("error processing data")
+	}
+
+	if res.DeletedCount == 0 {
+		return fmt.Errorf("client not found")
+	}
+
+	_, err = conn.ConsentCollection.DeleteMany(context.TODO(), bson.M{"clientId": clientId})
+
+	if err != nil {
+		return fmt.Errorf("error processing data")
+	}
+
+	return nil
+}
+
+func (o OAuthRepoImpl) findClient(conn *database.Connection, clientId string) (*domain.OAuthClient, error) {
+	client := new(domain.OAuthClient)
+	err := conn.OAuthClientCollection.FindOne(context.TODO(), bson.M{"clientId": clientId}).Decode(client)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return client, nil
+}
+
+// CheckAuthorization validates a request before the user has logged in, an error means the client or redirect uri
+// is invalid, a result is an error for the client's redirect uri and neither means the request is valid
+func (o OAuthRepoImpl) CheckAuthorization(r *domain.AuthorizationRequest) (*domain.AuthorizationResult, error) {
+	conn := database.MongoConnectionPool.Get().(*database.Connection)
+	defer database.MongoConnectionPool.Put(conn)
+
+	_, _, result, err := o.checkAuthorization(conn, r)
+
+	return result, err
+}
+
+// Authorize checks the request of a logged in user and issues a code when they have consented to the scopes.
+// Until the client and redirect uri are known to be valid errors are returned, after that they're sent
+// to the client in the redirect like RFC 6749 section 4.1.2.1 says.
+func (o OAuthRepoImpl) Authorize(userId primitive.ObjectID, r *domain.AuthorizationRequest) (*domain.AuthorizationResult, error) {
+	conn := database.MongoConnectionPool.Get().(*database.Connection)
+	defer database.MongoConnectionPool.Put(conn)
+
+	client, scopes, result, err := o.checkAuthorization(conn, r)
+
+	if result != nil || err != nil {
+		return result, err
+	}
+
+	var consent domain.Consent
+	err = conn.ConsentCollection.FindOne(context.TODO(), bson.M{"userId": userId, "clientId": client.ClientId}).Decode(&consent)
+
+	if err != nil && err != mongo.ErrNoDocuments {
+		return nil, fmt.Errorf("error processing data")
+	}
+
+	if !containsAll(consent.Scopes, scopes) {
+		if r.Approve == nil {
+			return &domain.AuthorizationResult{ConsentRequired: true, ClientName: client.Name, Scopes: scopes}, nil
+		}
+
+		if !*r.Approve {
+			return redirectWithError(r, "access_denied", "the user denied the request")
+		}
+
+		err = o.saveConsent(conn, userId, client.ClientId, scopes)
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	code, err := generateSignedToken()
+
+	if err != nil {
+		return nil, err
+	}
+
+	err = saveAuthorizationCode(code, &domain.AuthorizationCode{
+		ClientId:      client.ClientId,
+		UserId:        userId,
+		RedirectUri:   r.RedirectUri,
+		Scopes:        scopes,
+		Nonce:         r.Nonce,
+		CodeChallenge: r.CodeChallenge,
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return redirectWith(r, url.Values{"code": {code}})
+}
+
+// checkAuthorization returns the client and the requested scopes of a valid request, otherwise the error
+// or the redirect with the error like Authorize
+func (o OAuthRepoImpl) checkAuthorization(conn *database.Connection, r *domain.AuthorizationRequest) (*domain.OAuthClient, []string, *domain.AuthorizationResult, error) {
+	client, err := o.findClient(conn, r.ClientId)
+
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("unknown client")
+	}
+
+	if !contains(client.RedirectUris, r.RedirectUri) {
+		return nil, nil, nil, fmt.Errorf("redirect uri is not registered for this client")
+	}
+
+	if r.ResponseType != "code" {
+		result, err := redirectWithError(r, "unsupported_response_type", "only the code response type is supported")
+		return nil, nil, result, err
+	}
+
+	scopes := strings.Fields(r.Scope)
+
+	if len(scopes) == 0 {
+		result, err := redirectWithError(r, "invalid_scope", "scope is required")
+		return nil, nil, result, err
+	}
+
+	for _, scope := range scopes {
+		if !domain.IsScope(scope) || !contains(client.Scopes, scope) {
+			result, err := redirectWithError(r, "invalid_scope", "scope "+scope+" is not allowed for this client")
+			return nil, nil, result, err
+		}
+	}
+
+	// public clients can't keep a secret, PKCE is what stops a stolen code from being used
+	if r.CodeChallenge == "" && client.IsPublic {
+		result, err := redirectWithError(r, "invalid_request", "code_challenge is required")
+		return nil, nil, result, err
+	}
+
+	if r.CodeChallenge != "" && r.CodeChallengeMethod != "S256" {
+		result, err := redirectWithError(r, "invalid_request", "code_challenge_method has to be S256")
+		return nil, nil, result, err
+	}
+
+	return client, scopes, nil, nil
+}
+
+// saveConsent adds the scopes to what the user already allowed the client
+func (o OAuthRepoImpl) saveConsent(conn *database.Connection, userId primitive.ObjectID, clientId string, scopes []string) error {
+	filter := bson.M{"userId": userId, "clientId": clientId}
+	update := bson.M{
+		"$addToSet":    bson.M{"scopes": bson.M{"$each": scopes}},
+		"$set":         bson.M{"updatedAt": time.Now()},
+		"$setOnInsert": bson.M{"_id": primitive.NewObjectID(), "createdAt": time.Now()},
+	}
+
+	_, err := conn.ConsentCollection.UpdateOne(context.TODO(), filter, update, options.Update().SetUpsert(true))
+
+	if err != nil {
+		return fmt.Errorf("error processing data")
+	}
+
+	return nil
+}
+
+func saveAuthorizationCode(code string, authorizationCode *domain.AuthorizationCode) error {
+	var login domain.Authentication
+	codeHash, err := login.SignToken([]byte(code))
+
+	if err != nil {
+		return err
+	}
+
+	b, err := json.Marshal(authorizationCode)
+
+	if err != nil {
+		return err
+	}
+
+	ttl := time.Duration(config.ConfigInt("OAUTH_CODE_EXPIRATION", 60)) * time.Second
+	err = cache.SaveAuthorizationCode(string(codeHash), b, ttl)
+
+	if err != nil {
+		return fmt.Errorf("error processing data")
+	}
+
+	return nil
+}
+
+// Token exchanges a code for tokens, the authorization code grant from RFC 6749 section 4.1.3
+func (o OAuthRepoImpl) Token(r *domain.TokenRequest) (*domain.OAuthTokenResponse, error) {
+	conn := database.MongoConnectionPool.Get().(*database.Connection)
+	defer database.MongoConnectionPool.Put(conn)
+
+	if r.GrantType != "authorization_code" {
+		return nil, &domain.OAuthError{Code: "unsupported_grant_type"}
+	}
+
+	client, err := o.findClient(conn, r.ClientId)
+
+	if err != nil {
+		return nil, &domain.OAuthError{Code: "invalid_client"}
+	}
+
+	if !client.IsPublic && bcrypt.CompareHashAndPassword([]byte(client.ClientSecretHash), []byte(r.ClientSecret)) != nil {
+		return nil, &domain.OAuthError{Code: "invalid_client"}
+	}
+
+	var login domain.Authentication
+	codeHash, err := login.SignToken([]byte(r.Code))
+
+	if err != nil {
+		return nil, err
+	}
+
+	b, err := cache.TakeAuthorizationCode(string(codeHash))
+
+	if err != nil {
+		return nil, &domain.OAuthError{Code: "invalid_grant", Description: "the code is invalid or expired"}
+	}
+
+	code := new(domain.AuthorizationCode)
+	err = json.Unmarshal(b, code)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if code.ClientId != client.ClientId || code.RedirectUri != r.RedirectUri {
+		return nil, &domain.OAuthError{Code: "invalid_grant", Description: "the code was issued to another client or redirect uri"}
+	}
+
+	if code.CodeChallenge != "" && !authHelper.VerifyPKCE(r.CodeVerifier, code.CodeChallenge) {
+		return nil, &domain.OAuthError{Code: "invalid_grant", Description: "code_verifier does not match"}
+	}
+
+	var user domain.User
+	err = conn.UserCollection.FindOne(context.TODO(), bson.M{"_id": code.UserId}).Decode(&user)
+
+	if err != nil {
+		return nil, &domain.OAuthError{Code: "invalid_grant", Description: "user not found"}
+	}
+
+	return domain.GenerateOAuthTokens(&user, code)
+}
+
+// UserInfo the claims of the user the access token was issued for, limited to its scopes
+func (o OAuthRepoImpl) UserInfo(accessToken string) (map[string]interface{}, error) {
+	conn := database.MongoConnectionPool.Get().(*database.Connection)
+	defer database.MongoConnectionPool.Put(conn)
+
+	claims, err := domain.ParseOAuthAccessToken(accessToken)
+
+	if err != nil {
+		return nil, err
+	}
+
+	scopes := strings.Fields(claims.Scope)
+
+	if !contains(scopes, domain.ScopeOpenId) {
+		return nil, fmt.Errorf("the openid scope is required")
+	}
+
+	id, err := primitive.ObjectIDFromHex(claims.Subject)
+
+	if err != nil {
+		return nil, fmt.Errorf("invalid token")
+	}
+
+	var user domain.User
+	err = conn.UserCollection.FindOne(context.TODO(), bson.M{"_id": id}).Decode(&user)
+
+	if err != nil {
+		return nil, fmt.Errorf("user not found")
+	}
+
+	return domain.UserInfo(&user, scopes), nil
+}
+
+func (o OAuthRepoImpl) FindAllConsents(userId primitive.ObjectID) (*[]domain.Consent, error) {
+	conn := database.MongoConnectionPool.Get().(*database.Connection)
+	defer database.MongoConnectionPool.Put(conn)
+
+	cur, err := conn.ConsentCollection.Find(context.TODO(), bson.M{"userId": userId})
+
+	if err != nil {
+		return nil, err
+	}
+
+	consents := make([]domain.Consent, 0)
+	err = cur.All(context.TODO(), &consents)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &consents, nil
+}
+
+// DeleteConsent the client has to ask again next time, tokens it already has stay valid until they expire
+func (o OAuthRepoImpl) DeleteConsent(userId primitive.ObjectID, clientId string) error {
+	conn := database.MongoConnectionPool.Get().(*database.Connection)
+	defer database.MongoConnectionPool.Put(conn)
+
+	res, err := conn.ConsentCollection.DeleteOne(context.TODO(), bson.M{"userId": userId, "clientId": clientId})
+
+	if err != nil {
+		return fmt.Errorf("error processing data")
+	}
+
+	if res.DeletedCount == 0 {
+		return fmt.Errorf("consent not found")
+	}
+
+	return nil
+}
+
+func redirectWithError(r *domain.AuthorizationRequest, code string, description string) (*domain.AuthorizationResult, error) {
+	return redirectWith(r, url.Values{"error": {code}, "error_description": {description}})
+}
+
+// redirectWith adds params and the state to the client's redirect uri
+func redirectWith(r *domain.AuthorizationRequest, params url.Values) (*domain.AuthorizationResult, error) {
+	u, err := url.Parse(r.RedirectUri)
+
+	if err != nil {
+		return nil, err
+	}
+
+	q := u.Query()
+
+	for k, v := range params {
+		q[k] = v
+	}
+
+	if r.State != "" {
+		q.Set("state", r.State)
+	}
+
+	u.RawQuery = q.Encode()
+
+	return &domain.AuthorizationResult{RedirectUri: u.String()}, nil
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func containsAll(values []string, wanted []string) bool {
+	for _, w := range wanted {
+		if !contains(values, w) {
+			return false
+		}
+	}
+	return true
+}
+
+func NewOAuthRepoImpl() OAuthRepoImpl {
+	var oauthRepoImpl OAuthRepoImpl
+
+	return oauthRepoImpl
+}
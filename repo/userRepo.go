@@ -9,11 +9,14 @@ import (
 )
 
 type UserRepo interface {
-	FindAll(primitive.ObjectID, string, context.Context, *cache2.Cache, string, opentracing.Span) (*domain.UserResponse, error)
-	FindAllBlockedUsers(primitive.ObjectID, *cache2.Cache, context.Context, string) (*[]domain.UserDto, error)
+	FindAll(primitive.ObjectID, *domain.PageRequest, context.Context, *cache2.Cache, string, opentracing.Span) (*domain.UserResponse, error)
+	FindAllBlockedUsers(primitive.ObjectID, *domain.PageRequest, *cache2.Cache, context.Context, string) (*domain.UserResponse, error)
 	Create(*domain.User) error
 	FindByID(primitive.ObjectID, *cache2.Cache, context.Context) (*domain.UserDto, error)
 	FindByUsername(string, *cache2.Cache, context.Context) (*domain.UserDto, error)
+	FindProfile(primitive.ObjectID, string, string, *cache2.Cache, context.Context) (*domain.ViewUserProfile, error)
+	FindFollowList(string, string, string, *domain.PageRequest, *cache2.Cache, context.Context) (*domain.Page, error)
+	SearchUsers(primitive.ObjectID, string, string, *domain.PageRequest, *cache2.Cache, context.Context) (*domain.UserResponse, error)
 	UpdateByID(primitive.ObjectID, *domain.User) (*domain.UserDto, error)
 	UpdateProfileVisibility(primitive.ObjectID, *domain.UpdateProfileVisibility, *cache2.Cache, context.Context) error
 	UpdateMessageAcceptance(primitive.ObjectID, *domain.UpdateMessageAcceptance, *cache2.Cache, context.Context) error
@@ -25,7 +28,8 @@ type UserRepo interface {
 	UpdateDisplayFollowerCount(primitive.ObjectID, *domain.UpdateDisplayFollowerCount, *cache2.Cache) error
 	FollowUser(username string, currentUser string, rdb *cache2.Cache) error
 	UnfollowUser(username string, currentUser string, rdb *cache2.Cache) error
-	UpdatePassword(primitive.ObjectID, string) error
+	UpdatePassword(primitive.ObjectID, string) (*domain.User, error)
+	UpdateRole(string, *domain.UpdateRole, *cache2.Cache) (*domain.User, error)
 	UpdateFlagCount(*domain.Flag) error
 	BlockUser(primitive.ObjectID, string, *cache2.Cache, context.Context, string) error
 	UnblockUser(primitive.ObjectID, string, *cache2.Cache, context.Context, string) error
@@ -2,6 +2,7 @@ package repo
 
 import (
 	"context"
+	"example.com/app/config"
 	"example.com/app/database"
 	"example.com/app/domain"
 	"example.com/app/events"
@@ -15,8 +16,8 @@ import (
 	"go.mongodb.org/mongo-driver/mongo/options"
 	"go.mongodb.org/mongo-driver/mongo/readconcern"
 	"go.mongodb.org/mongo-driver/mongo/writeconcern"
-	"log"
-	"strconv"
+	"regexp"
+	"sort"
 	"time"
 )
 
@@ -24,11 +25,9 @@ type UserRepoImpl struct {
 	users        []domain.User
 	user         domain.User
 	userDto      domain.UserDto
-	userDtoList  []domain.UserDto
-	userResponse domain.UserResponse
 }
 
-func (u UserRepoImpl) FindAll(id primitive.ObjectID, page string, ctx context.Context, rdb *cache.Cache, username string, span opentracing.Span) (*domain.UserResponse, error) {
+func (u UserRepoImpl) FindAll(id primitive.ObjectID, page *domain.PageRequest, ctx context.Context, rdb *cache.Cache, username string, span opentracing.Span) (*domain.UserResponse, error) {
 	childSpan, _ := opentracing.StartSpanFromContext(ctx, "child2")
 	defer childSpan.Finish()
 	var data domain.UserDto
@@ -49,43 +48,18 @@ func (u UserRepoImpl) FindAll(id primitive.ObjectID, page string, ctx context.Co
 		}
 	}
 
-	conn := database.MongoConnectionPool.Get().(*database.Connection)
-	defer database.MongoConnectionPool.Put(conn)
-
-	findOptions := options.FindOptions{}
-	perPage := 10
-	pageNumber, err := strconv.Atoi(page)
-
-	if err != nil {
-		return nil, fmt.Errorf("page must be a number")
-	}
-	findOptions.SetSkip((int64(pageNumber) - 1) * int64(perPage))
-	findOptions.SetLimit(int64(perPage))
-
-	// Get all users
-	cur, err := conn.UserCollection.Find(ctx, bson.M{
+	// Get all users, the block lists hold usernames
+	return u.findUserPage(ctx, bson.M{
 		"profileIsViewable": true,
 		"$and": []interface{}{
 			bson.M{"_id": bson.M{"$ne": id}},
-			bson.M{"_id": bson.M{"$nin": currentUser.BlockByList}},
-			bson.M{"_id": bson.M{"$nin": currentUser.BlockList}},
+			bson.M{"username": bson.M{"$nin": currentUser.BlockByList}},
+			bson.M{"username": bson.M{"$nin": currentUser.BlockList}},
 		},
-	}, &findOptions)
-
-	if err != nil {
-		return nil, err
-	}
-
-	if err = cur.All(ctx, &u.userDtoList); err != nil {
-		log.Fatal(err)
-	}
-
-	u.userResponse = domain.UserResponse{Users: &u.userDtoList, CurrentPage: page}
-
-	return &u.userResponse, nil
+	}, page)
 }
 
-func (u UserRepoImpl) FindAllBlockedUsers(id primitive.ObjectID, rdb *cache.Cache, ctx context.Context, username string) (*[]domain.UserDto, error) {
+func (u UserRepoImpl) FindAllBlockedUsers(id primitive.ObjectID, page *domain.PageRequest, rdb *cache.Cache, ctx context.Context, username string) (*domain.UserResponse, error) {
 	var data domain.UserDto
 
 	err := rdb.Get(ctx, util.GenerateKey(username, "finduserbyusername"), &data)
@@ -104,26 +78,230 @@ func (u UserRepoImpl) FindAllBlockedUsers(id primitive.ObjectID, rdb *cache.Cach
 		}
 	}
 
+	return u.findUserPage(ctx, bson.M{"username": bson.M{"$in": currentUser.BlockList}}, page)
+}
+
+// findUserPage one page of the users matching filter in the order and after the cursor of page
+func (u UserRepoImpl) findUserPage(ctx context.Context, filter bson.M, page *domain.PageRequest) (*domain.UserResponse, error) {
+	sortOrder, after, err := domain.UserPageQuery(page)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if after != nil {
+		filter = bson.M{"$and": []interface{}{filter, after}}
+	}
+
 	conn := database.MongoConnectionPool.Get().(*database.Connection)
 	defer database.MongoConnectionPool.Put(conn)
 
-	query := bson.M{"_id": bson.M{"$in": currentUser.BlockList}}
+	limit := domain.PageSize(page.Limit)
 
-	// Get all users
-	cur, err := conn.UserCollection.Find(context.TODO(), query)
+	// one extra document tells whether there is another page
+	findOptions := options.Find().SetSort(sortOrder).SetLimit(limit + 1)
+
+	cur, err := conn.UserCollection.Find(ctx, filter, findOptions)
 
 	if err != nil {
 		return nil, fmt.Errorf("error processing data")
 	}
 
-	var results []domain.UserDto
-	if err = cur.All(context.TODO(), &results); err != nil {
-		panic(err)
+	users := make([]domain.UserDto, 0)
+	if err = cur.All(ctx, &users); err != nil {
+		return nil, fmt.Errorf("error processing data")
+	}
+
+	response := &domain.UserResponse{}
+
+	if int64(len(users)) > limit {
+		users = users[:limit]
+		response.HasMore = true
+		response.NextCursor = domain.UserCursor(page, &users[limit-1])
 	}
 
-	u.userDtoList = results
+	response.Users = &users
 
-	return &u.userDtoList, nil
+	return response, nil
+}
+
+// SearchUsers ranks the viewable users matching q and pages through them, see domain.SearchScore.
+// The hits of a query are cached for SEARCH_CACHE_TTL, the caller's blocks are filtered out of them afterwards.
+func (u UserRepoImpl) SearchUsers(id primitive.ObjectID, username string, q string, page *domain.PageRequest, rdb *cache.Cache, ctx context.Context) (*domain.UserResponse, error) {
+	c, err := domain.DecodeCursor(page.Cursor)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if page.Cursor != "" && c.Sort != domain.UserSortRelevance {
+		return nil, domain.ErrInvalidCursor
+	}
+
+	currentUser, err := u.findCachedByUsername(username, rdb, ctx)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var hits []domain.UserSearchHit
+
+	err = rdb.Get(ctx, domain.SearchCacheKey(q), &hits)
+
+	if err != nil {
+		hits, err = u.searchUsers(q, ctx)
+
+		if err != nil {
+			return nil, err
+		}
+
+		err = rdb.Set(&cache.Item{
+			Ctx:   ctx,
+			Key:   domain.SearchCacheKey(q),
+			Value: hits,
+			TTL:   domain.SearchCacheTTL(),
+		})
+
+		if err != nil {
+			fmt.Println("Error caching search...", err)
+		}
+	} else {
+		fmt.Println("Found in Cache in search users...")
+	}
+
+	limit := domain.PageSize(page.Limit)
+	users := make([]domain.UserDto, 0, limit)
+	response := &domain.UserResponse{}
+	var last *domain.UserSearchHit
+
+	for i := range hits {
+		hit := &hits[i]
+
+		if hit.User.Id == id || containsUsername(currentUser.BlockList, hit.User.Username) ||
+			containsUsername(currentUser.BlockByList, hit.User.Username) {
+			continue
+		}
+
+		if page.Cursor != "" && !hit.After(c) {
+			continue
+		}
+
+		if int64(len(users)) == limit {
+			response.HasMore = true
+			response.NextCursor = domain.SearchCursor(last)
+			break
+		}
+
+		users = append(users, hit.User)
+		last = hit
+	}
+
+	response.Users = &users
+
+	return response, nil
+}
+
+// searchUsers the ranked hits of q, from a username prefix query, a fuzzy query on the usernames sharing
+// a pair of characters with q and a text query on the usernames and taglines
+func (u UserRepoImpl) searchUsers(q string, ctx context.Context) ([]domain.UserSearchHit, error) {
+	conn := database.MongoConnectionPool.Get().(*database.Connection)
+	defer database.MongoConnectionPool.Put(conn)
+
+	maxResults := int64(domain.SearchMaxResults())
+
+	scores := map[primitive.ObjectID]*domain.UserSearchHit{}
+
+	// usernames are lowercase, so the prefix query can use the username index, the shortest usernames rank
+	// highest but the limit keeps the first ones in order to make the results the same every time
+	prefixOptions := options.Find().SetSort(bson.D{{"username", 1}}).SetLimit(maxResults)
+	cur, err := conn.UserCollection.Find(ctx, bson.M{"username": bson.M{"$regex": "^" + regexp.QuoteMeta(q)}, "profileIsViewable": true}, prefixOptions)
+
+	if err != nil {
+		return nil, fmt.Errorf("error processing data")
+	}
+
+	var users []domain.UserDto
+	if err = cur.All(ctx, &users); err != nil {
+		return nil, fmt.Errorf("error processing data")
+	}
+
+	for _, user := range users {
+		scores[user.Id] = &domain.UserSearchHit{User: user, Score: domain.SearchScore(q, user.Username, 0)}
+	}
+
+	// every fuzzy candidate is scored, the limit is applied to the ranked hits
+	if domain.SearchFuzzyDistance(q) > 0 {
+		cur, err := conn.UserCollection.Find(ctx, bson.M{"username": bson.M{"$regex": domain.SearchFuzzyPattern(q)}, "profileIsViewable": true})
+
+		if err != nil {
+			return nil, fmt.Errorf("error processing data")
+		}
+
+		for cur.Next(ctx) {
+			var user domain.UserDto
+			if err = cur.Decode(&user); err != nil {
+				_ = cur.Close(ctx)
+				return nil, fmt.Errorf("error processing data")
+			}
+
+			if score := domain.SearchScore(q, user.Username, 0); score > 0 {
+				scores[user.Id] = &domain.UserSearchHit{User: user, Score: score}
+			}
+		}
+
+		err = cur.Err()
+		_ = cur.Close(ctx)
+
+		if err != nil {
+			return nil, fmt.Errorf("error processing data")
+		}
+	}
+
+	textOptions := options.Find().
+		SetProjection(bson.M{"textScore": bson.M{"$meta": "textScore"}}).
+		SetSort(bson.M{"textScore": bson.M{"$meta": "textScore"}}).
+		SetLimit(maxResults)
+
+	cur, err = conn.UserCollection.Find(ctx, bson.M{"$text": bson.M{"$search": q}, "profileIsViewable": true}, textOptions)
+
+	if err != nil {
+		return nil, fmt.Errorf("error processing data")
+	}
+
+	var matches []struct {
+		domain.UserDto `bson:",inline"`
+		TextScore      float64 `bson:"textScore"`
+	}
+	if err = cur.All(ctx, &matches); err != nil {
+		return nil, fmt.Errorf("error processing data")
+	}
+
+	for _, match := range matches {
+		score := domain.SearchScore(q, match.Username, match.TextScore)
+
+		if hit, ok := scores[match.Id]; !ok || hit.Score < score {
+			scores[match.Id] = &domain.UserSearchHit{User: match.UserDto, Score: score}
+		}
+	}
+
+	hits := make([]domain.UserSearchHit, 0, len(scores))
+
+	for _, hit := range scores {
+		hits = append(hits, *hit)
+	}
+
+	sort.Slice(hits, func(i, j int) bool {
+		if hits[i].Score != hits[j].Score {
+			return hits[i].Score > hits[j].Score
+		}
+		return hits[i].User.Username < hits[j].User.Username
+	})
+
+	if int64(len(hits)) > maxResults {
+		hits = hits[:maxResults]
+	}
+
+	return hits, nil
 }
 
 func (u UserRepoImpl) Create(user *domain.User) error {
@@ -174,7 +352,7 @@ func (u UserRepoImpl) Create(user *domain.User) error {
 	}
 
 	if u.userDto.Username == user.Username {
-		return fmt.Errorf("username is taken")
+		return domain.ErrUsernameTaken
 	}
 
 	return fmt.Errorf("email is taken")
@@ -217,16 +395,13 @@ func (u UserRepoImpl) FindByUsername(username string, rdb *cache.Cache, ctx cont
 	conn := database.MongoConnectionPool.Get().(*database.Connection)
 	defer database.MongoConnectionPool.Put(conn)
 
-	err := conn.UserCollection.FindOne(context.TODO(), bson.M{"username": username, "$and":
-	[]interface{}{
-		bson.M{"profileIsViewable": true,
-		},
-	}}).Decode(&u.userDto)
+	// like FindByID this caches the whole user, whoever reads it decides what the viewer may see
+	err := conn.UserCollection.FindOne(context.TODO(), bson.M{"username": username}).Decode(&u.userDto)
 
 	if err != nil {
 		// ErrNoDocuments means that the filter did not match any documents in the collection
 		if err == mongo.ErrNoDocuments {
-			return nil, fmt.Errorf("cannot find user")
+			return nil, err
 		}
 		return nil, fmt.Errorf("error processing data")
 	}
@@ -249,6 +424,135 @@ func (u UserRepoImpl) FindByUsername(username string, rdb *cache.Cache, ctx cont
 	return &u.userDto, nil
 }
 
+// FindProfile the profile of username as the viewer may see it, users who hid their profile or are on either
+// side of a block are not found. Both users are read from the cache FindByID and FindByUsername fill.
+func (u UserRepoImpl) FindProfile(viewerId primitive.ObjectID, viewerUsername string, username string, rdb *cache.Cache, ctx context.Context) (*domain.ViewUserProfile, error) {
+	user, err := u.findCachedByUsername(username, rdb, ctx)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if user.Id == viewerId {
+		profile := domain.ViewUserProfileMapper(domain.UserDtoMapper(*user))
+		profile.FollowerCount = &user.FollowerCount
+		return profile, nil
+	}
+
+	if !user.ProfileIsViewable {
+		return nil, mongo.ErrNoDocuments
+	}
+
+	viewer, err := u.findCachedByUsername(viewerUsername, rdb, ctx)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if hiddenFrom(user, viewer) {
+		return nil, mongo.ErrNoDocuments
+	}
+
+	return domain.ViewUserProfileMapper(domain.UserDtoMapper(*user)), nil
+}
+
+// FindFollowList a page of the followers, following or mutuals of username, mongo.ErrNoDocuments when the viewer
+// can't see the user's profile. The entries are limited like profiles: viewable and not blocked either way.
+func (u UserRepoImpl) FindFollowList(viewerUsername string, username string, list string, page *domain.PageRequest, rdb *cache.Cache, ctx context.Context) (*domain.Page, error) {
+	viewer, err := u.findCachedByUsername(viewerUsername, rdb, ctx)
+
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := u.findCachedByUsername(username, rdb, ctx)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if hiddenFrom(user, viewer) {
+		return nil, mongo.ErrNoDocuments
+	}
+
+	var usernames []string
+
+	switch list {
+	case domain.FollowListFollowers:
+		usernames = user.Followers
+	case domain.FollowListFollowing:
+		usernames = user.Following
+	case domain.FollowListMutuals:
+		for _, follower := range user.Followers {
+			if containsUsername(user.Following, follower) {
+				usernames = append(usernames, follower)
+			}
+		}
+	}
+
+	if usernames == nil {
+		usernames = []string{}
+	}
+
+	blocked := make([]string, 0, len(viewer.BlockList)+len(viewer.BlockByList))
+	blocked = append(append(blocked, viewer.BlockList...), viewer.BlockByList...)
+
+	users, err := u.findUserPage(ctx, bson.M{
+		"username": bson.M{"$in": usernames, "$nin": blocked},
+		// viewers see themselves in the lists they are in
+		"$or": []interface{}{bson.M{"profileIsViewable": true}, bson.M{"_id": viewer.Id}},
+	}, page)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &domain.Page{Items: domain.FollowViewListMapper(*users.Users, viewer), NextCursor: users.NextCursor, HasMore: users.HasMore}, nil
+}
+
+// hiddenFrom true when user made their profile private or one of them blocked the other, users always see themselves.
+// A block only clears the blocker's cache, so the lists of both users are checked.
+func hiddenFrom(user *domain.UserDto, viewer *domain.UserDto) bool {
+	if user.Id == viewer.Id {
+		return false
+	}
+
+	return !user.ProfileIsViewable ||
+		containsUsername(user.BlockList, viewer.Username) || containsUsername(user.BlockByList, viewer.Username) ||
+		containsUsername(viewer.BlockList, user.Username) || containsUsername(viewer.BlockByList, user.Username)
+}
+
+func (u UserRepoImpl) findCachedByUsername(username string, rdb *cache.Cache, ctx context.Context) (*domain.UserDto, error) {
+	var data domain.UserDto
+
+	err := rdb.Get(ctx, util.GenerateKey(username, "finduserbyusername"), &data)
+
+	if err == nil {
+		fmt.Println("Found in Cache in find profile...")
+		return &data, nil
+	}
+
+	return u.FindByUsername(username, rdb, ctx)
+}
+
+// uncacheUser drops the cached profile of the other user of a block or follow, it shows their lists and follower count
+func uncacheUser(rdb *cache.Cache, username string) {
+	err := rdb.Delete(context.TODO(), util.GenerateKey(username, "finduserbyusername"))
+
+	if err != nil {
+		fmt.Println("Error removing from cache...", err)
+	}
+}
+
+func containsUsername(usernames []string, username string) bool {
+	for _, u := range usernames {
+		if u == username {
+			return true
+		}
+	}
+	return false
+}
+
 func (u UserRepoImpl) UpdateByID(id primitive.ObjectID, user *domain.User) (*domain.UserDto, error) {
 	conn := database.MongoConnectionPool.Get().(*database.Connection)
 	defer database.MongoConnectionPool.Put(conn)
@@ -257,8 +561,12 @@ func (u UserRepoImpl) UpdateByID(id primitive.ObjectID, user *domain.User) (*dom
 	filter := bson.D{{"_id", id}}
 	update := bson.D{{"$set", bson.D{{"tokenHash", user.TokenHash}, {"tokenExpiresAt", user.TokenExpiresAt}}}}
 
-	conn.UserCollection.FindOneAndUpdate(context.TODO(),
-		filter, update, opts)
+	err := conn.UserCollection.FindOneAndUpdate(context.TODO(),
+		filter, update, opts).Err()
+
+	if err != nil {
+		return nil, err
+	}
 
 	return &u.userDto, nil
 }
@@ -590,18 +898,72 @@ func (u UserRepoImpl) UpdateVerification(id primitive.ObjectID, user *domain.Upd
 	return nil
 }
 
-func (u UserRepoImpl) UpdatePassword(id primitive.ObjectID, password string) error {
+// UpdatePassword returns the user as they are after the update
+func (u UserRepoImpl) UpdatePassword(id primitive.ObjectID, password string) (*domain.User, error) {
 	conn := database.MongoConnectionPool.Get().(*database.Connection)
 	defer database.MongoConnectionPool.Put(conn)
 
-	opts := options.FindOneAndUpdate().SetUpsert(true)
+	opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
 	filter := bson.D{{"_id", id}}
-	update := bson.D{{"$set", bson.D{{"password", password}, {"tokenHash", ""}, {"tokenExpiresAt", 0}, {"updatedAt", time.Now()}}}}
+	// the last PASSWORD_HISTORY hashes are kept so old passwords can't be used again
+	history := bson.D{{"$each", []string{password}}, {"$slice", -config.ConfigInt("PASSWORD_HISTORY", 5)}}
+	update := bson.D{
+		{"$set", bson.D{{"password", password}, {"tokenHash", ""}, {"tokenExpiresAt", 0}, {"updatedAt", time.Now()}}},
+		{"$push", bson.D{{"passwordHistory", history}}},
+	}
 
-	conn.UserCollection.FindOneAndUpdate(context.TODO(),
-		filter, update, opts)
+	var user domain.User
+	err := conn.UserCollection.FindOneAndUpdate(context.TODO(),
+		filter, update, opts).Decode(&user)
 
-	return nil
+	if err != nil {
+		return nil, err
+	}
+
+	return &user, nil
+}
+
+func (u UserRepoImpl) UpdateRole(username string, role *domain.UpdateRole, rdb *cache.Cache) (*domain.User, error) {
+	conn := database.MongoConnectionPool.Get().(*database.Connection)
+	defer database.MongoConnectionPool.Put(conn)
+
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	filter := bson.M{"username": username}
+	update := bson.M{"$set": bson.M{"role": role.Role, "permissions": role.Permissions, "updatedAt": time.Now()}}
+
+	err := conn.UserCollection.FindOneAndUpdate(context.TODO(),
+		filter, update, opts).Decode(&u.user)
+
+	if err != nil {
+		return nil, err
+	}
+
+	// tokens carry the permissions they were issued with, so the user has to log in again to get the new ones
+	err = AuthRepoImpl{}.RevokeAllSessions(u.user.Id)
+
+	if err != nil {
+		return nil, err
+	}
+
+	go func() {
+		err := events.HandleKafkaMessage(err, &u.user, 200)
+		if err != nil {
+			return
+		}
+	}()
+
+	go func() {
+		err := rdb.Delete(context.TODO(), util.GenerateKey(username, "finduserbyusername"))
+
+		if err != nil {
+			fmt.Println("Not in cache, update role")
+			return
+		}
+
+		fmt.Println("Removed from cache, update role")
+	}()
+
+	return &u.user, nil
 }
 
 func (u UserRepoImpl) UpdateFlagCount(flag *domain.Flag) error {
@@ -726,6 +1088,8 @@ func (u UserRepoImpl) BlockUser(id primitive.ObjectID, username string, rdb *cac
 		return
 	}()
 
+	go uncacheUser(rdb, username)
+
 	go func() {
 		user := new(domain.User)
 		err = conn.UserCollection.FindOne(context.TODO(), bson.D{{"username", u.userDto.Username}}).Decode(user)
@@ -863,6 +1227,8 @@ func (u UserRepoImpl) UnblockUser(id primitive.ObjectID, username string, rdb *c
 		return
 	}()
 
+	go uncacheUser(rdb, username)
+
 	go func() {
 		user := new(domain.User)
 		err = conn.UserCollection.FindOne(context.TODO(), bson.D{{"username", u.userDto.Username}}).Decode(user)
@@ -1003,6 +1369,8 @@ func (u UserRepoImpl) FollowUser(username string, currentUser string, rdb *cache
 		return
 	}()
 
+	go uncacheUser(rdb, username)
+
 	return nil
 }
 
@@ -1117,6 +1485,8 @@ func (u UserRepoImpl) UnfollowUser(username string, currentUser string, rdb *cac
 		return
 	}()
 
+	go uncacheUser(rdb, username)
+
 	return nil
 }
 
@@ -1,7 +1,9 @@
 package router
 
 import (
+	"example.com/app/domain"
 	"example.com/app/handlers"
+	"example.com/app/middleware"
 	"example.com/app/repo"
 	"example.com/app/services"
 	"github.com/gofiber/fiber/v2"
@@ -13,32 +15,95 @@ import (
 func SetupRoutes(app *fiber.App) {
 	uh := handlers.UserHandler{UserService: services.NewUserService(repo.NewUserRepoImpl())}
 	ah := handlers.AuthHandler{AuthService: services.NewAuthService(repo.NewAuthRepoImpl())}
+	oh := handlers.OAuthHandler{OAuthService: services.NewOAuthService(repo.NewOAuthRepoImpl())}
 	app.Use(recover.New())
 	api := app.Group("", logger.New())
 
+	api.Get("/.well-known/jwks.json", ah.GetJwks)
+	api.Get("/.well-known/openid-configuration", oh.Discovery)
+
+	// public routes have to be registered before a group's middleware, fiber runs handlers in the order they were added
 	auth := api.Group("/auth")
 	auth.Post("/login", ah.Login)
+	auth.Post("/magic-link", ah.MagicLinkQuery)
+	auth.Post("/magic-link/:token", ah.MagicLinkLogin)
+	auth.Get("/oidc/:provider", ah.OidcLogin)
+	auth.Get("/oidc/:provider/callback", ah.OidcCallback)
+	auth.Post("/refresh", ah.RefreshToken)
+	auth.Post("/mfa/verify", ah.VerifyMfa)
 	auth.Post("/reset", ah.ResetPasswordQuery)
 	auth.Put("/reset/:token", ah.ResetPassword)
 	auth.Get("/account/:code", ah.VerifyCode)
+	auth.Post("/account/resend", ah.ResendVerification)
+
+	protectedAuth := auth.Group("", middleware.IsLoggedIn)
+	protectedAuth.Put("/unlock/:username", middleware.RequirePermission(domain.PermissionUnlockUsers), ah.UnlockAccount)
+	protectedAuth.Delete("/impersonate", ah.EndImpersonation)
+
+	// api keys can't change how the account logs in or mint more keys, and neither can admins impersonating the user
+	sessionAuth := protectedAuth.Group("", middleware.RequireSession, middleware.BlockImpersonation)
+	sessionAuth.Post("/impersonate/:username", middleware.RequirePermission(domain.PermissionImpersonate), ah.Impersonate)
+	sessionAuth.Post("/logout", ah.Logout)
+	sessionAuth.Get("/sessions", ah.GetAllSessions)
+	sessionAuth.Delete("/sessions", ah.RevokeOtherSessions)
+	sessionAuth.Delete("/sessions/:id", ah.RevokeSession)
+	sessionAuth.Get("/devices", ah.GetAllDevices)
+	sessionAuth.Put("/devices/:id/confirm", ah.ConfirmDevice)
+	sessionAuth.Put("/devices/:id/deny", ah.DenyDevice)
+	sessionAuth.Post("/mfa/enroll", ah.EnrollMfa)
+	sessionAuth.Post("/mfa/confirm", ah.ConfirmMfa)
+	sessionAuth.Post("/mfa/disable", ah.DisableMfa)
+	sessionAuth.Get("/identities", ah.GetAllIdentities)
+	sessionAuth.Post("/oidc/:provider/link", ah.LinkIdentity)
+	sessionAuth.Delete("/oidc/:provider", ah.UnlinkIdentity)
+	sessionAuth.Get("/api-keys", ah.GetAllApiKeys)
+	sessionAuth.Post("/api-keys", ah.CreateApiKey)
+	sessionAuth.Delete("/api-keys/:id", ah.DeleteApiKey)
+
+	oauth := api.Group("/oauth")
+	oauth.Post("/token", oh.Token)
+	oauth.Get("/userinfo", oh.UserInfo)
+	oauth.Post("/userinfo", oh.UserInfo)
+	oauth.Get("/authorize", oh.StartAuthorization)
+
+	protectedOAuth := oauth.Group("", middleware.IsLoggedIn)
+	protectedOAuth.Get("/authorize", middleware.RequireSession, middleware.BlockImpersonation, oh.Authorize)
+	protectedOAuth.Post("/authorize", middleware.RequireSession, middleware.BlockImpersonation, oh.Authorize)
+	protectedOAuth.Get("/consents", oh.GetAllConsents)
+	protectedOAuth.Delete("/consents/:clientId", oh.DeleteConsent)
+	protectedOAuth.Get("/clients", middleware.RequirePermission(domain.PermissionManageClients), oh.GetAllClients)
+	protectedOAuth.Post("/clients", middleware.RequirePermission(domain.PermissionManageClients), oh.CreateClient)
+	protectedOAuth.Delete("/clients/:clientId", middleware.RequirePermission(domain.PermissionManageClients), oh.DeleteClient)
 
 	user := api.Group("/users")
-	user.Get("/", uh.GetAllUsers)
-	user.Get("/blocked", uh.GetAllBlockedUsers)
-	user.Post("flag/:username", uh.UpdateFlagCount)
 	user.Post("/", uh.CreateUser)
-	user.Put("/profile-visibility", uh.UpdateProfileVisibility)
-	user.Put("/follower-count", uh.UpdateDisplayFollowerCount)
-	user.Put("/message-acceptance", uh.UpdateMessageAcceptance)
-	user.Put("/current-badge", uh.UpdateCurrentBadge)
-	user.Put("/profile-photo", uh.UpdateProfilePicture)
-	user.Put("/background-photo", uh.UpdateProfileBackgroundPicture)
-	user.Put("/current-tagline", uh.UpdateCurrentTagline)
-	user.Put("/block/:username", uh.BlockUser)
-	user.Put("/unblock/:username", uh.UnblockUser)
-	user.Put("/follow/:username", uh.FollowUser)
-	user.Put("/unfollow/:username", uh.UnfollowUser)
-	user.Delete("/delete", uh.DeleteByID)
+
+	protectedUser := user.Group("", middleware.IsLoggedIn)
+	protectedUser.Get("/", uh.GetAllUsers)
+	protectedUser.Get("/blocked", uh.GetAllBlockedUsers)
+	protectedUser.Get("/search", uh.SearchUsers)
+	protectedUser.Get("/security-activity", ah.GetSecurityActivity)
+	protectedUser.Post("flag/:username", uh.UpdateFlagCount)
+	protectedUser.Put("/profile-visibility", uh.UpdateProfileVisibility)
+	protectedUser.Put("/follower-count", uh.UpdateDisplayFollowerCount)
+	protectedUser.Put("/message-acceptance", uh.UpdateMessageAcceptance)
+	protectedUser.Put("/current-badge", uh.UpdateCurrentBadge)
+	protectedUser.Put("/profile-photo", uh.UpdateProfilePicture)
+	protectedUser.Put("/background-photo", uh.UpdateProfileBackgroundPicture)
+	protectedUser.Put("/current-tagline", uh.UpdateCurrentTagline)
+	protectedUser.Put("/block/:username", uh.BlockUser)
+	protectedUser.Put("/unblock/:username", uh.UnblockUser)
+	protectedUser.Put("/follow/:username", uh.FollowUser)
+	protectedUser.Put("/unfollow/:username", uh.UnfollowUser)
+	protectedUser.Delete("/delete", middleware.RequireSession, middleware.BlockImpersonation, uh.DeleteByID)
+	protectedUser.Put("/password", middleware.RequireSession, middleware.BlockImpersonation, ah.ChangePassword)
+	protectedUser.Put("/email", middleware.RequireSession, middleware.BlockImpersonation, ah.ChangeEmail)
+	protectedUser.Put("/role/:username", middleware.RequirePermission(domain.PermissionManageRoles), uh.UpdateRole)
+	protectedUser.Get("/:username/followers", uh.GetFollowers)
+	protectedUser.Get("/:username/following", uh.GetFollowing)
+	protectedUser.Get("/:username/mutuals", uh.GetMutuals)
+	// registered last so /blocked, /search and /security-activity aren't taken for usernames
+	protectedUser.Get("/:username", uh.GetUserProfile)
 }
 
 func Setup() *fiber.App {
@@ -3,26 +3,256 @@ package services
 import (
 	"example.com/app/domain"
 	"example.com/app/repo"
-	"golang.org/x/crypto/bcrypt"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"strings"
 )
 
 type AuthService interface {
-	Login(username string, password string, ip string, ips []string) (*domain.UserDto, string, error)
+	Login(username string, password string, ip string, ips []string, userAgent string) (*domain.UserDto, *domain.AuthTokens, error)
+	MagicLinkQuery(email string) error
+	MagicLinkLogin(token string, ip string, ips []string, userAgent string) (*domain.UserDto, *domain.AuthTokens, error)
+	OidcAuthorizationUrl(provider string, linkUserId primitive.ObjectID) (string, string, error)
+	OidcCallback(provider string, code string, state string, binding string, ip string, ips []string, userAgent string) (*domain.UserDto, *domain.AuthTokens, error)
+	GetAllIdentities(userId primitive.ObjectID) (*[]domain.Identity, error)
+	UnlinkIdentity(userId primitive.ObjectID, provider string) error
+	CreateApiKey(userId primitive.ObjectID, apiKey *domain.CreateApiKey) (*domain.ApiKeyCredentials, error)
+	GetAllApiKeys(userId primitive.ObjectID) (*[]domain.ApiKey, error)
+	DeleteApiKey(userId primitive.ObjectID, id primitive.ObjectID) error
+	RefreshToken(token string) (*domain.AuthTokens, error)
+	Logout(sessionId string) error
+	GetAllSessions(userId primitive.ObjectID, currentSessionId string) (*[]domain.Session, error)
+	RevokeSession(userId primitive.ObjectID, id primitive.ObjectID) error
+	RevokeOtherSessions(userId primitive.ObjectID, currentSessionId string) error
+	GetAllDevices(userId primitive.ObjectID) (*[]domain.Device, error)
+	ConfirmDevice(userId primitive.ObjectID, id primitive.ObjectID) error
+	DenyDevice(userId primitive.ObjectID, id primitive.ObjectID, currentSessionId string) error
+	GetSecurityActivity(userId primitive.ObjectID, cursor string, limit string) (*domain.Page, error)
+	EnrollMfa(userId primitive.ObjectID) (*domain.MfaEnrollment, error)
+	ConfirmMfa(userId primitive.ObjectID, code string) ([]string, error)
+	DisableMfa(userId primitive.ObjectID, code string) error
+	Impersonate(actor *domain.Authentication, username string, ip string) (*domain.UserDto, *domain.AuthTokens, error)
+	EndImpersonation(u *domain.Authentication, ip string) error
+	UnlockAccount(username string) error
+	VerifyMfa(mfaToken string, code string, ip string, ips []string, userAgent string) (*domain.UserDto, *domain.AuthTokens, error)
 	ResetPasswordQuery(email string) error
+	ChangePassword(id primitive.ObjectID, currentPassword string, password string, currentSessionId string) error
+	ChangeEmail(id primitive.ObjectID, email string, password string) error
 	ResetPassword(token, password string) error
 	VerifyCode(code string) error
+	ResendVerification(email string) error
+	SweepUnverifiedUsers() error
 }
 
 type DefaultAuthService struct {
 	repo repo.AuthRepo
 }
 
-func (a DefaultAuthService) Login(username string, password string, ip string, ips []string) (*domain.UserDto, string, error) {
-	u, token, err := a.repo.Login(username, password, ip, ips)
+func (a DefaultAuthService) Login(username string, password string, ip string, ips []string, userAgent string) (*domain.UserDto, *domain.AuthTokens, error) {
+	u, tokens, err := a.repo.Login(username, password, ip, ips, userAgent)
 	if err != nil {
-		return nil, "", err
+		return nil, nil, err
 	}
-	return u, token, nil
+	return u, tokens, nil
+}
+
+func (a DefaultAuthService) MagicLinkQuery(email string) error {
+	err := a.repo.MagicLinkQuery(strings.ToLower(email))
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (a DefaultAuthService) MagicLinkLogin(token string, ip string, ips []string, userAgent string) (*domain.UserDto, *domain.AuthTokens, error) {
+	u, tokens, err := a.repo.MagicLinkLogin(token, ip, ips, userAgent)
+	if err != nil {
+		return nil, nil, err
+	}
+	return u, tokens, nil
+}
+
+func (a DefaultAuthService) OidcAuthorizationUrl(provider string, linkUserId primitive.ObjectID) (string, string, error) {
+	url, binding, err := a.repo.OidcAuthorizationUrl(strings.ToLower(provider), linkUserId)
+	if err != nil {
+		return "", "", err
+	}
+	return url, binding, nil
+}
+
+func (a DefaultAuthService) OidcCallback(provider string, code string, state string, binding string, ip string, ips []string, userAgent string) (*domain.UserDto, *domain.AuthTokens, error) {
+	u, tokens, err := a.repo.OidcCallback(strings.ToLower(provider), code, state, binding, ip, ips, userAgent)
+	if err != nil {
+		return nil, nil, err
+	}
+	return u, tokens, nil
+}
+
+func (a DefaultAuthService) GetAllIdentities(userId primitive.ObjectID) (*[]domain.Identity, error) {
+	identities, err := a.repo.FindAllIdentities(userId)
+	if err != nil {
+		return nil, err
+	}
+	return identities, nil
+}
+
+func (a DefaultAuthService) UnlinkIdentity(userId primitive.ObjectID, provider string) error {
+	err := a.repo.UnlinkIdentity(userId, strings.ToLower(provider))
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (a DefaultAuthService) CreateApiKey(userId primitive.ObjectID, apiKey *domain.CreateApiKey) (*domain.ApiKeyCredentials, error) {
+	credentials, err := a.repo.CreateApiKey(userId, apiKey)
+	if err != nil {
+		return nil, err
+	}
+	return credentials, nil
+}
+
+func (a DefaultAuthService) GetAllApiKeys(userId primitive.ObjectID) (*[]domain.ApiKey, error) {
+	apiKeys, err := a.repo.FindAllApiKeys(userId)
+	if err != nil {
+		return nil, err
+	}
+	return apiKeys, nil
+}
+
+func (a DefaultAuthService) DeleteApiKey(userId primitive.ObjectID, id primitive.ObjectID) error {
+	err := a.repo.DeleteApiKey(userId, id)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (a DefaultAuthService) RefreshToken(token string) (*domain.AuthTokens, error) {
+	tokens, err := a.repo.RefreshToken(token)
+	if err != nil {
+		return nil, err
+	}
+	return tokens, nil
+}
+
+func (a DefaultAuthService) Logout(sessionId string) error {
+	err := a.repo.Logout(sessionId)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (a DefaultAuthService) GetAllSessions(userId primitive.ObjectID, currentSessionId string) (*[]domain.Session, error) {
+	sessions, err := a.repo.FindAllSessions(userId, currentSessionId)
+	if err != nil {
+		return nil, err
+	}
+	return sessions, nil
+}
+
+func (a DefaultAuthService) RevokeSession(userId primitive.ObjectID, id primitive.ObjectID) error {
+	err := a.repo.RevokeSession(userId, id)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (a DefaultAuthService) RevokeOtherSessions(userId primitive.ObjectID, currentSessionId string) error {
+	err := a.repo.RevokeOtherSessions(userId, currentSessionId)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (a DefaultAuthService) GetAllDevices(userId primitive.ObjectID) (*[]domain.Device, error) {
+	devices, err := a.repo.FindAllDevices(userId)
+	if err != nil {
+		return nil, err
+	}
+	return devices, nil
+}
+
+func (a DefaultAuthService) ConfirmDevice(userId primitive.ObjectID, id primitive.ObjectID) error {
+	err := a.repo.ConfirmDevice(userId, id)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (a DefaultAuthService) DenyDevice(userId primitive.ObjectID, id primitive.ObjectID, currentSessionId string) error {
+	err := a.repo.DenyDevice(userId, id, currentSessionId)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (a DefaultAuthService) GetSecurityActivity(userId primitive.ObjectID, cursor string, limit string) (*domain.Page, error) {
+	page, err := a.repo.FindSecurityActivity(userId, cursor, domain.PageSize(limit))
+	if err != nil {
+		return nil, err
+	}
+	return page, nil
+}
+
+func (a DefaultAuthService) EnrollMfa(userId primitive.ObjectID) (*domain.MfaEnrollment, error) {
+	enrollment, err := a.repo.EnrollMfa(userId)
+	if err != nil {
+		return nil, err
+	}
+	return enrollment, nil
+}
+
+func (a DefaultAuthService) ConfirmMfa(userId primitive.ObjectID, code string) ([]string, error) {
+	codes, err := a.repo.ConfirmMfa(userId, code)
+	if err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+func (a DefaultAuthService) DisableMfa(userId primitive.ObjectID, code string) error {
+	err := a.repo.DisableMfa(userId, code)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (a DefaultAuthService) VerifyMfa(mfaToken string, code string, ip string, ips []string, userAgent string) (*domain.UserDto, *domain.AuthTokens, error) {
+	u, tokens, err := a.repo.VerifyMfa(mfaToken, code, ip, ips, userAgent)
+	if err != nil {
+		return nil, nil, err
+	}
+	return u, tokens, nil
+}
+
+func (a DefaultAuthService) Impersonate(actor *domain.Authentication, username string, ip string) (*domain.UserDto, *domain.AuthTokens, error) {
+	u, tokens, err := a.repo.Impersonate(actor, username, ip)
+	if err != nil {
+		return nil, nil, err
+	}
+	return u, tokens, nil
+}
+
+func (a DefaultAuthService) EndImpersonation(u *domain.Authentication, ip string) error {
+	err := a.repo.EndImpersonation(u, ip)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (a DefaultAuthService) UnlockAccount(username string) error {
+	err := a.repo.UnlockAccount(username)
+	if err != nil {
+		return err
+	}
+	return nil
 }
 
 func (a DefaultAuthService) ResetPasswordQuery(email string) error {
@@ -33,9 +263,24 @@ func (a DefaultAuthService) ResetPasswordQuery(email string) error {
 	return nil
 }
 
+func (a DefaultAuthService) ChangePassword(id primitive.ObjectID, currentPassword string, password string, currentSessionId string) error {
+	err := a.repo.ChangePassword(id, currentPassword, password, currentSessionId)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (a DefaultAuthService) ChangeEmail(id primitive.ObjectID, email string, password string) error {
+	err := a.repo.ChangeEmail(id, strings.ToLower(email), password)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
 func (a DefaultAuthService) ResetPassword(token, password string) error {
-	hashedPassword, _ := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
-	err := a.repo.ResetPassword(token, string(hashedPassword))
+	err := a.repo.ResetPassword(token, password)
 	if err != nil {
 		return err
 	}
@@ -50,6 +295,26 @@ func (a DefaultAuthService) VerifyCode(code string) error {
 	return nil
 }
 
+func (a DefaultAuthService) ResendVerification(email string) error {
+	err := a.repo.ResendVerification(strings.ToLower(email))
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (a DefaultAuthService) SweepUnverifiedUsers() error {
+	err := a.repo.RemindUnverifiedUsers()
+	if err != nil {
+		return err
+	}
+	err = a.repo.PurgeUnverifiedUsers()
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
 func NewAuthService(repository repo.AuthRepo) DefaultAuthService {
 	return DefaultAuthService{repository}
 }
\ No newline at end of file
@@ -0,0 +1,99 @@
+package services
+
+import (
+	"example.com/app/domain"
+	"example.com/app/repo"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type OAuthService interface {
+	CreateClient(client *domain.CreateOAuthClient) (*domain.OAuthClientCredentials, error)
+	GetAllClients() (*[]domain.OAuthClient, error)
+	DeleteClient(clientId string) error
+	CheckAuthorization(request *domain.AuthorizationRequest) (*domain.AuthorizationResult, error)
+	Authorize(userId primitive.ObjectID, request *domain.AuthorizationRequest) (*domain.AuthorizationResult, error)
+	Token(request *domain.TokenRequest) (*domain.OAuthTokenResponse, error)
+	UserInfo(accessToken string) (map[string]interface{}, error)
+	GetAllConsents(userId primitive.ObjectID) (*[]domain.Consent, error)
+	DeleteConsent(userId primitive.ObjectID, clientId string) error
+}
+
+type DefaultOAuthService struct {
+	repo repo.OAuthRepo
+}
+
+func (o DefaultOAuthService) CreateClient(client *domain.CreateOAuthClient) (*domain.OAuthClientCredentials, error) {
+	credentials, err := o.repo.CreateClient(client)
+	if err != nil {
+		return nil, err
+	}
+	return credentials, nil
+}
+
+func (o DefaultOAuthService) GetAllClients() (*[]domain.OAuthClient, error) {
+	clients, err := o.repo.FindAllClients()
+	if err != nil {
+		return nil, err
+	}
+	return clients, nil
+}
+
+func (o DefaultOAuthService) DeleteClient(clientId string) error {
+	err := o.repo.DeleteClient(clientId)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (o DefaultOAuthService) CheckAuthorization(request *domain.AuthorizationRequest) (*domain.AuthorizationResult, error) {
+	result, err := o.repo.CheckAuthorization(request)
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func (o DefaultOAuthService) Authorize(userId primitive.ObjectID, request *domain.AuthorizationRequest) (*domain.AuthorizationResult, error) {
+	result, err := o.repo.Authorize(userId, request)
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func (o DefaultOAuthService) Token(request *domain.TokenRequest) (*domain.OAuthTokenResponse, error) {
+	tokens, err := o.repo.Token(request)
+	if err != nil {
+		return nil, err
+	}
+	return tokens, nil
+}
+
+func (o DefaultOAuthService) UserInfo(accessToken string) (map[string]interface{}, error) {
+	claims, err := o.repo.UserInfo(accessToken)
+	if err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+func (o DefaultOAuthService) GetAllConsents(userId primitive.ObjectID) (*[]domain.Consent, error) {
+	consents, err := o.repo.FindAllConsents(userId)
+	if err != nil {
+		return nil, err
+	}
+	return consents, nil
+}
+
+func (o DefaultOAuthService) DeleteConsent(userId primitive.ObjectID, clientId string) error {
+	err := o.repo.DeleteConsent(userId, clientId)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func NewOAuthService(repository repo.OAuthRepo) DefaultOAuthService {
+	return DefaultOAuthService{repository}
+}
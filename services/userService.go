@@ -3,22 +3,25 @@ package services
 import (
 	"context"
 	"example.com/app/domain"
+	"example.com/app/email"
 	"example.com/app/repo"
 	cache2 "github.com/go-redis/cache/v8"
 	"github.com/gofiber/fiber/v2/utils"
 	"github.com/opentracing/opentracing-go"
 	"go.mongodb.org/mongo-driver/bson/primitive"
-	"golang.org/x/crypto/bcrypt"
 	"strings"
 	"time"
 )
 
 type UserService interface {
-	GetAllUsers(primitive.ObjectID, string, context.Context, *cache2.Cache, string, opentracing.Span) (*domain.UserResponse, error)
-	GetAllBlockedUsers(primitive.ObjectID, *cache2.Cache, context.Context, string) (*[]domain.UserDto, error)
+	GetAllUsers(primitive.ObjectID, *domain.PageRequest, context.Context, *cache2.Cache, string, opentracing.Span) (*domain.UserResponse, error)
+	GetAllBlockedUsers(primitive.ObjectID, *domain.PageRequest, *cache2.Cache, context.Context, string) (*domain.UserResponse, error)
 	CreateUser(*domain.User) error
 	GetUserByID(primitive.ObjectID, *cache2.Cache, context.Context) (*domain.UserDto, error)
 	GetUserByUsername(string, *cache2.Cache, context.Context) (*domain.UserDto, error)
+	GetUserProfile(primitive.ObjectID, string, string, *cache2.Cache, context.Context) (*domain.ViewUserProfile, error)
+	GetFollowList(string, string, string, *domain.PageRequest, *cache2.Cache, context.Context) (*domain.Page, error)
+	SearchUsers(primitive.ObjectID, string, string, *domain.PageRequest, *cache2.Cache, context.Context) (*domain.UserResponse, error)
 	UpdateProfileVisibility(primitive.ObjectID, *domain.UpdateProfileVisibility, *cache2.Cache, context.Context) error
 	UpdateMessageAcceptance(primitive.ObjectID, *domain.UpdateMessageAcceptance, *cache2.Cache, context.Context) error
 	UpdateCurrentBadge(primitive.ObjectID, *domain.UpdateCurrentBadge, *cache2.Cache, context.Context) error
@@ -28,6 +31,7 @@ type UserService interface {
 	UpdateDisplayFollowerCount(primitive.ObjectID, *domain.UpdateDisplayFollowerCount, *cache2.Cache) error
 	UpdateVerification(primitive.ObjectID, *domain.UpdateVerification) error
 	UpdatePassword(primitive.ObjectID, string) error
+	UpdateRole(string, *domain.UpdateRole, *cache2.Cache) (*domain.User, error)
 	UpdateFlagCount(*domain.Flag) error
 	FollowUser(username string, currentUser string, rdb *cache2.Cache) error
 	UnfollowUser(username string, currentUser string, rdb *cache2.Cache) error
@@ -41,7 +45,7 @@ type DefaultUserService struct {
 	repo repo.UserRepo
 }
 
-func (s DefaultUserService) GetAllUsers(id primitive.ObjectID, page string, ctx context.Context, rdb *cache2.Cache, username string, span opentracing.Span) (*domain.UserResponse, error) {
+func (s DefaultUserService) GetAllUsers(id primitive.ObjectID, page *domain.PageRequest, ctx context.Context, rdb *cache2.Cache, username string, span opentracing.Span) (*domain.UserResponse, error) {
 	//childSpan := opentracing.StartSpan("child", opentracing.ChildOf(span.Context()))
 	//defer childSpan.Finish()
 	u, err := s.repo.FindAll(id, page, ctx, rdb, username, span)
@@ -51,8 +55,8 @@ func (s DefaultUserService) GetAllUsers(id primitive.ObjectID, page string, ctx
 	return  u, nil
 }
 
-func (s DefaultUserService) GetAllBlockedUsers(id primitive.ObjectID, rdb *cache2.Cache, ctx context.Context, username string) (*[]domain.UserDto, error) {
-	u, err := s.repo.FindAllBlockedUsers(id, rdb, ctx, username)
+func (s DefaultUserService) GetAllBlockedUsers(id primitive.ObjectID, page *domain.PageRequest, rdb *cache2.Cache, ctx context.Context, username string) (*domain.UserResponse, error) {
+	u, err := s.repo.FindAllBlockedUsers(id, page, rdb, ctx, username)
 	if err != nil {
 		return nil, err
 	}
@@ -62,8 +66,22 @@ func (s DefaultUserService) GetAllBlockedUsers(id primitive.ObjectID, rdb *cache
 func (s DefaultUserService) CreateUser(user *domain.User) error {
 	user.Username = strings.ToLower(user.Username)
 	user.Email = strings.ToLower(user.Email)
-	hashedPassword, _ := bcrypt.GenerateFromPassword([]byte(user.Password), bcrypt.DefaultCost)
-	user.Password = string(hashedPassword)
+
+	err := domain.ValidatePassword(user.Password, user.Username, user.Email, nil)
+
+	if err != nil {
+		return err
+	}
+
+	hashedPassword, err := domain.HashPassword(user.Password)
+
+	if err != nil {
+		return err
+	}
+
+	user.Password = hashedPassword
+	user.PasswordHistory = []string{hashedPassword}
+
 	a := new(domain.Authentication)
 	h := utils.UUIDv4()
 	signedHash, err := a.SignToken([]byte(h))
@@ -74,11 +92,15 @@ func (s DefaultUserService) CreateUser(user *domain.User) error {
 
 	hash := h + "-" + string(signedHash)
 	user.VerificationCode = hash
+	user.VerificationCodeExpiresAt = domain.VerificationCodeExpiresAt()
 
 	err = s.repo.Create(user)
 	if err != nil {
 		return err
 	}
+
+	email.Send(email.VerificationEmail(user.Email, user.Username, user.VerificationCode))
+
 	return nil
 }
 
@@ -98,6 +120,30 @@ func (s DefaultUserService) GetUserByUsername(username string, rdb *cache2.Cache
 	return u, nil
 }
 
+func (s DefaultUserService) GetUserProfile(viewerId primitive.ObjectID, viewerUsername string, username string, rdb *cache2.Cache, ctx context.Context) (*domain.ViewUserProfile, error) {
+	profile, err := s.repo.FindProfile(viewerId, viewerUsername, username, rdb, ctx)
+	if err != nil {
+		return nil, err
+	}
+	return profile, nil
+}
+
+func (s DefaultUserService) GetFollowList(viewerUsername string, username string, list string, page *domain.PageRequest, rdb *cache2.Cache, ctx context.Context) (*domain.Page, error) {
+	p, err := s.repo.FindFollowList(viewerUsername, username, list, page, rdb, ctx)
+	if err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+func (s DefaultUserService) SearchUsers(id primitive.ObjectID, username string, q string, page *domain.PageRequest, rdb *cache2.Cache, ctx context.Context) (*domain.UserResponse, error) {
+	u, err := s.repo.SearchUsers(id, username, q, page, rdb, ctx)
+	if err != nil {
+		return nil, err
+	}
+	return u, nil
+}
+
 func (s DefaultUserService) UpdateProfileVisibility(id primitive.ObjectID, user *domain.UpdateProfileVisibility, rdb *cache2.Cache, ctx context.Context) error {
 	user.UpdatedAt = time.Now()
 	err := s.repo.UpdateProfileVisibility(id, user, rdb, ctx)
@@ -161,13 +207,24 @@ func (s DefaultUserService) UpdateCurrentTagline(id primitive.ObjectID, user *do
 }
 
 func (s DefaultUserService) UpdatePassword(id primitive.ObjectID, password string) error {
-	err := s.repo.UpdatePassword(id, password)
+	_, err := s.repo.UpdatePassword(id, password)
 	if err != nil {
 		return err
 	}
 	return nil
 }
 
+func (s DefaultUserService) UpdateRole(username string, role *domain.UpdateRole, rdb *cache2.Cache) (*domain.User, error) {
+	if role.Permissions == nil {
+		role.Permissions = []string{}
+	}
+	u, err := s.repo.UpdateRole(username, role, rdb)
+	if err != nil {
+		return nil, err
+	}
+	return u, nil
+}
+
 func (s DefaultUserService) UpdateVerification(id primitive.ObjectID, user *domain.UpdateVerification) error {
 	user.UpdatedAt = time.Now()
 	err := s.repo.UpdateVerification(id, user)
@@ -0,0 +1,22 @@
+package services
+
+import (
+	"example.com/app/config"
+	"fmt"
+	"time"
+)
+
+// RunVerificationSweep reminds and purges unverified users every VERIFICATION_SWEEP_INTERVAL minutes, it never returns
+func RunVerificationSweep(s AuthService) {
+	interval := time.Duration(config.ConfigInt("VERIFICATION_SWEEP_INTERVAL", 60)) * time.Minute
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		err := s.SweepUnverifiedUsers()
+
+		if err != nil {
+			fmt.Println("Error sweeping unverified users...", err)
+		}
+	}
+}
@@ -15,6 +15,8 @@ func CreateUser(createUserDto *domain.CreateUserDto) *domain.User {
 	user.Password = createUserDto.Password
 	user.IsVerified = false
 	user.IsLocked = false
+	user.Role = domain.RoleUser
+	user.Permissions = []string{}
 	user.ProfileIsViewable = true
 	user.AcceptMessages = true
 	user.BlockList = []string{}